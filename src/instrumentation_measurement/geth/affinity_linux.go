@@ -0,0 +1,16 @@
+//go:build linux
+// +build linux
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// setCPUAffinity pins the calling OS thread to the given CPU.
+// Call go_runtime.LockOSThread first, so that the goroutine stays on that thread.
+func setCPUAffinity(cpu int) error {
+	var set unix.CPUSet
+	set.Zero()
+	set.Set(cpu)
+	// pid 0 means the calling thread
+	return unix.SchedSetaffinity(0, &set)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// setCPUAffinity is only supported on Linux
+func setCPUAffinity(cpu int) error {
+	return errors.New("setting CPU affinity is only supported on Linux")
+}
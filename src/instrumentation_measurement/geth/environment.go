@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/imapp-pl/gas-cost-estimator/src/instrumentation_measurement/geth/measure"
+)
+
+// environment is the call environment read from -envFile. Every field is optional and stands for the flag of the same name,
+// in the same format, e.g. hex addresses and value as a decimal or 0x-prefixed hex string
+type environment struct {
+	Caller   string            `json:"caller"`
+	Address  string            `json:"address"`
+	Value    string            `json:"value"`
+	GasLimit *uint64           `json:"gasLimit"`
+	Calldata *string           `json:"calldata"`
+	Storage  map[string]string `json:"storage"`
+	Fork     string            `json:"fork"`
+}
+
+// applyEnvironment sets the flags which are not given explicitly from the environment file,
+// so that its fields are parsed and validated the same as the flags
+func applyEnvironment(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var env environment
+	decoder := json.NewDecoder(file)
+	// a misspelled field would silently fall back to the default otherwise
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&env); err != nil {
+		return err
+	}
+
+	values := map[string]string{"caller": env.Caller, "address": env.Address, "value": env.Value, "fork": env.Fork}
+	if env.GasLimit != nil {
+		values["gasLimit"] = strconv.FormatUint(*env.GasLimit, 10)
+	}
+	for name, value := range values {
+		if value == "" || isFlagSet(name) {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	// the calldata may be set to empty, unlike the default
+	if env.Calldata != nil && !isFlagSet("calldata") {
+		if err := flags.Set("calldata", *env.Calldata); err != nil {
+			return fmt.Errorf("calldata: %v", err)
+		}
+	}
+	if len(env.Storage) > 0 && !isFlagSet("storage") {
+		keys := make([]string, 0, len(env.Storage))
+		for key := range env.Storage {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := flags.Set("storage", key+"="+env.Storage[key]); err != nil {
+				return fmt.Errorf("storage: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// flagEnvironment is the environment the executions start from, as given by the flags, see measure.Environment.
+// The balance of -senderBalance must cover the value sent by every execution
+func flagEnvironment(value *big.Int) (measure.Environment, error) {
+	env := measure.Environment{
+		Address:           measure.DefaultContractAddress,
+		Nonce:             *noncePtr,
+		CreateCollision:   *createCollisionPtr,
+		Storage:           contractStorage,
+		PreimageRecording: *preimageRecordingPtr,
+		BlockHashes:       blockHashes,
+	}
+	if isFlagSet("hashSeed") {
+		env.HashSeed = hashSeedPtr
+	}
+	if *addressPtr != "" {
+		address, err := parseAddress(*addressPtr)
+		if err != nil {
+			return env, fmt.Errorf("Invalid address: %v", err)
+		}
+		env.Address = address
+	}
+	env.WarmAccessList = warmAccess.accessList(env.Address)
+	if *extraEipsPtr != "" {
+		eips, err := parseSizes(*extraEipsPtr)
+		if err != nil {
+			return env, fmt.Errorf("Invalid EIP: %v", err)
+		}
+		for _, eip := range eips {
+			if !vm.ValidEip(eip) {
+				return env, fmt.Errorf("Invalid EIP: %d is not one the interpreter can enable", eip)
+			}
+		}
+		env.ExtraEips = eips
+	}
+	if *stateFilePtr != "" {
+		snapshot, err := readStateFile(*stateFilePtr)
+		if err != nil {
+			return env, fmt.Errorf("Invalid state file: %v", err)
+		}
+		env.StateSnapshot = snapshot
+	}
+	if *senderBalancePtr != "" {
+		senderBalance, err := parseValue(*senderBalancePtr)
+		if err != nil {
+			return env, fmt.Errorf("Invalid sender balance: %v", err)
+		}
+		if senderBalance.Cmp(value) < 0 {
+			return env, fmt.Errorf("Insufficient sender balance: %v wei, less than the value %v wei sent by every execution", senderBalance, value)
+		}
+		env.SenderBalance = senderBalance
+	}
+	return env, nil
+}
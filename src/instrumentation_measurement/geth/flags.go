@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/imapp-pl/gas-cost-estimator/src/instrumentation_measurement/geth/measure"
+)
+
+// the flags of the command line, parsed by parseCommandLine and validated by validateFlags
+var (
+	bytecodePtr            = flag.String("bytecode", "", "EVM bytecode to execute and measure, - to read it from STDIN")
+	bytecodeFilePtr        = flag.String("bytecodeFile", "", "Path to a file with EVM bytecode to execute and measure, takes precedence over -bytecode")
+	sampleSizePtr          = flag.Int("sampleSize", 1, "Size of the sample - number of measured repetitions of execution")
+	targetSEMPtr           = flag.Duration("targetSEM", 0, "If positive, the sample goes on past -sampleSize until the standard error of the mean of the run durations drops below this (e.g. 10ns), modes all and total")
+	maxSamplesPtr          = flag.Int("maxSamples", 100000, "Cap of the number of runs of a sample with -targetSEM")
+	calibratePtr           = flag.Bool("calibrate", false, "If true, first measures an empty program (STOP) with the same sample size and environment, and reports its mean as the harness overhead subtracted from the reported durations, next to the raw ones (modes all and total)")
+	epochsPtr              = flag.Int("epochs", 1, "Number of times the whole sample is repeated, pausing for -epochPause in between, to tell the drift between epochs from the jitter within (modes all and total). CSV rows are prefixed with the epoch")
+	epochPausePtr          = flag.Duration("epochPause", 100*time.Millisecond, "Pause between the epochs of -epochs")
+	printEachPtr           = flag.Bool("printEach", false, "If true, will print the duration of every run to STDERR, and the whole instrumentation of every run in mode all, which is slow and verbose for large samples")
+	printCSVPtr            = flag.Bool("printCSV", false, "If true, will print a CSV with standard results to STDOUT")
+	printJSONPtr           = flag.Bool("printJSON", false, "If true, will print every sample as a JSON line to STDOUT (modes all and total), or every step in mode traceJSON")
+	modePtr                = flag.String("mode", "all", "Measurement mode. Available options: "+strings.Join(measure.Modes, ", "))
+	calldataPtr            = flag.String("calldata", "", "Calldata (hex) passed as input to the executed bytecode. If not given, a constant 32KB calldata is used")
+	calldataSizesPtr       = flag.String("calldataSizes", "", "Comma-separated sizes (bytes) of the calldata, measuring the bytecode once per size with the calldata (-calldata, or the constant one) repeated or truncated to that size. CSV rows are prefixed with the size")
+	gasLimitPtr            = flag.Uint64("gasLimit", math.MaxUint64, "Gas limit for the execution")
+	preimageRecordingPtr   = flag.Bool("preimageRecording", false, "If true, the interpreter records the preimage of every KECCAK256 hash in the state (vm.Config EnablePreimageRecording)")
+	extraEipsPtr           = flag.String("extraEips", "", "Comma-separated EIPs enabled on top of the rules of -fork (vm.Config ExtraEips), e.g. 2929 on istanbul. Available options: 1344, 1884, 2200, 2929, 3198, 3529")
+	valuePtr               = flag.String("value", "0", "Value (wei, decimal or 0x-prefixed hex) sent along with the execution")
+	senderBalancePtr       = flag.String("senderBalance", "", "Balance (wei, decimal or 0x-prefixed hex) of the caller before every execution, which the -value it sends is paid from, so every run starts with the same balance. If not given, a caller sending value is given 2^128 wei on top of its balance")
+	callerPtr              = flag.String("caller", "", "Address (hex, 20 bytes) of the caller, i.e. the origin of the execution")
+	addressPtr             = flag.String("address", "", "Address (hex, 20 bytes) the bytecode is executed at, returned by ADDRESS and the account of SELFBALANCE and of calls to itself. If not given, the address of runtime.Execute is used. The address is printed before measuring")
+	noncePtr               = flag.Uint64("nonce", 0, "Nonce of the account the bytecode is executed at, at the start of every execution, which the address of the contract created by its first CREATE derives from. The address is printed before measuring")
+	createCollisionPtr     = flag.Bool("createCollision", false, "If true, the address of the contract created by the first CREATE of the bytecode has code already, so that the CREATE fails with an address collision")
+	stateFilePtr           = flag.String("stateFile", "", "Path to a JSON file with accounts (address to balance, nonce, code and storage, as in a genesis alloc) installed into the state before the measurement. If not given, the state is empty")
+	envFilePtr             = flag.String("envFile", "", "Path to a JSON file with the call environment: caller, address, value, gasLimit, calldata, storage and fork. Flags given explicitly take precedence over its fields")
+	hashSeedPtr            = flag.Uint64("hashSeed", 0, "If given, BLOCKHASH returns the keccak256 of the seed and the block number (8 bytes big-endian each) for the blocks without -blockHash, in place of the keccak256 of the decimal block number")
+	blockNumberPtr         = flag.Uint64("blockNumber", 0, "Number of the block the executions run in, returned by NUMBER")
+	timePtr                = flag.String("time", "", "Time of the block (seconds since the epoch, decimal or 0x-prefixed hex) returned by TIMESTAMP. If not given, the current time is used")
+	difficultyPtr          = flag.String("difficulty", "0", "Difficulty of the block (decimal or 0x-prefixed hex) returned by DIFFICULTY")
+	baseFeePtr             = flag.String("baseFee", "", "Base fee of the block (wei, decimal or 0x-prefixed hex) returned by BASEFEE, since London only. If not given, 1 gwei is used")
+	initCodePtr            = flag.String("initCode", "", "Init code (hex) passed as calldata, for the bytecode to copy into memory and CREATE or CREATE2 from.")
+	deployPtr              = flag.String("deploy", "", "Creation bytecode (hex) of a contract deployed before the measurement, so that the measured bytecode can call into it")
+	forkPtr                = flag.String("fork", "london", "Hard fork which rules are used for execution. Available options: "+strings.Join(measure.ForkNames(), ", "))
+	printMetaPtr           = flag.Bool("printMeta", false, "If true, will print a preamble of # commented lines with host and build metadata to STDOUT")
+	csvHeaderPtr           = flag.Bool("csvHeader", false, "If true, will print a header row before the CSV results")
+	resultCSVPtr           = flag.String("resultCSV", "", "Path to a sibling CSV file recording success, return data length, number of executed opcodes and memory expansions of every run")
+	timerPtr               = flag.String("timer", "runtimeNano", "Clock used to time executions. Available options: runtimeNano, time (fallback to time.Since)")
+	traceMemoryPtr         = flag.Bool("traceMemory", false, "If true, trace CSV rows get an extra column with the memory size in words")
+	traceMemoryLimitPtr    = flag.Int("traceMemoryLimit", 0, "If positive, trace CSV rows get an extra column with up to that many first bytes of memory (hex)")
+	traceStackDepthPtr     = flag.Int("traceStackDepth", measure.DefaultTraceStackColumns, "Number of stack elements (from the bottom of the stack) printed in every trace CSV row, padded with empty columns")
+	traceBranchPtr         = flag.Bool("traceBranch", false, "If true, trace CSV rows and the rows of mode opcode get extra columns with whether a JUMP or JUMPI jumped (1 or 0) and its destination, told from the pc of the next step")
+	traceOpNumericPtr      = flag.Bool("traceOpNumeric", false, "If true, trace CSV rows get an extra column with the opcode as a decimal byte value")
+	traceStoragePtr        = flag.Bool("traceStorage", false, "If true, trace CSV rows get an extra column with the storage of the executing contract (key=value hex pairs) at SLOAD and SSTORE steps")
+	traceStorageDeltaPtr   = flag.Bool("traceStorageDelta", false, "If true, the storage column has only the slots changed since the previous step with storage, implies -traceStorage")
+	reuseEVMPtr            = flag.Bool("reuseEVM", false, "If true, the EVM is built once and reused for the calls of all the runs, rather than built anew for every run (modes all and total)")
+	warmupPtr              = flag.Int("warmup", 1, "Number of discarded warm-up executions before the sample, instrumented like the measured ones but not recorded. The number and total duration of the warm-up runs are printed to STDERR")
+	aggregatePtr           = flag.Bool("aggregate", false, "If true, mode all prints the count and the summed and mean measurement of every executed opcode, sorted by opcode, in place of every instruction")
+	summaryPtr             = flag.Bool("summary", false, "If true, will print summary statistics of the run durations to STDERR after the sample (modes all and total)")
+	gcModePtr              = flag.String("gcMode", "default", "Garbage collection during the sample. Available options: default (Go runtime decides, effectively off with GOGC=off), each (collect before every run), off (collect once before the sample and disable GC for its duration)")
+	cpuPtr                 = flag.Int("cpu", -1, "If not negative, pins the measurement to the given CPU (Linux only)")
+	workersPtr             = flag.Int("workers", 1, "Number of programs from -batchFile, or requests to -serve, measured in parallel, each worker on its own OS thread (pinned to consecutive CPUs starting at -cpu, if given)")
+	compareForkPtr         = flag.String("compareFork", "", "Hard fork which rules the bytecode is measured under once more, after -fork, with the same sample and environment, reporting the difference of mean durations (modes all and total). CSV rows are prefixed with the fork")
+	printConfigPtr         = flag.Bool("printConfig", false, "If true, will print the effective configuration of the runs (fork, gas limit, value, caller, block, storage etc.), with the defaults filled in, as a JSON line to STDERR before measuring")
+	servePtr               = flag.String("serve", "", "Address (e.g. localhost:8080) of an HTTP server measuring the bytecodes (hex, or JSON requests with bytecode, calldata, sampleSize, mode and fork) POSTed to /measure with -workers workers, responding with JSON summary statistics and output, in place of the bytecode flags")
+	metricsAddrPtr         = flag.String("metricsAddr", "", "Address (e.g. localhost:9090) of an HTTP server exposing the numbers of measured programs, runs and errors, and a histogram of run durations at /metrics, in the Prometheus text format")
+	baselinePtr            = flag.String("baseline", "", "Bytecode (hex) of a baseline program measured after the bytecode with the same sample, reporting the difference of mean durations (modes all and total). CSV rows are prefixed with the program index, 0 for the bytecode and 1 for the baseline")
+	expectGasPtr           = flag.Int64("expectGas", -1, "Gas the bytecode is expected to use, mode verify runs it once and fails if the gas used (gas limit less the gas left over) differs")
+	replayTracePtr         = flag.String("replayTrace", "", "Path to a trace CSV (mode trace, with or without its header) of the bytecode, mode replay measures only the instructions at its pcs")
+	measureRangePtr        = flag.String("measureRange", "", "Range X:Y of pcs (decimal or 0x-prefixed hex, both included) of the executed code, as in mode trace, which the per-opcode rows of modes all and opcode are limited to, leaving out the setup code around a measured region. Both ends must be pcs of instructions")
+	codePadPtr             = flag.Int("codePad", 0, "Number of inert bytes (a STOP followed by INVALIDs) appended to the bytecode, so that CODESIZE and CODECOPY see a larger code without changing the execution")
+	precompilePtr          = flag.String("precompile", "", "Precompiled contract (name, e.g. ecrecover, sha256 or modexp, or address) called by a generated program measured in place of the bytecode, after copying -precompileInput to memory. It must be active under -fork")
+	precompileInputPtr     = flag.String("precompileInput", "", "Input (hex) of the -precompile call")
+	precompileInputSizePtr = flag.String("precompileInputSize", "", "Comma-separated sizes (bytes) the -precompileInput is repeated or truncated to, random bytes if not given, measuring the -precompile call once per size. CSV rows are prefixed with the size")
+	logDataSizePtr         = flag.String("logDataSize", "", "Comma-separated sizes (bytes) of a memory buffer populated in front of the bytecode, with its size and offset left on top of the stack for a LOG0-LOG4 to log, measuring the bytecode once per size. CSV rows are prefixed with the size")
+	stackDepthPtr          = flag.String("stackDepth", "", "Comma-separated stack depths of mode stacksweep, counting the words of -stack, 0 to 1024 every 16 words by default")
+	preMemoryPtr           = flag.Int("preMemory", 0, "Words of memory expanded to by an MSTORE8 put in front of the bytecode (after the stack prelude), so that the measured opcodes do not pay the expansion, 0 for none")
+	stackPtr               = flag.String("stack", "", "Comma-separated words (hex, bottom to top) pushed onto the stack by PUSH32s put in front of the bytecode, e.g. the operands of the measured opcode. The per-opcode rows leave the PUSH32s out, unless -measureRange is given, but the run durations (and mode total) still include their cost: compare against a -baseline, which gets the same PUSH32s")
+	repeatBytecodePtr      = flag.Int("repeatBytecode", 1, "Number of times the bytecode is concatenated, to amortize the fixed cost of a call. The bytecode must leave the stack balanced and must not end with STOP")
+	batchFilePtr           = flag.String("batchFile", "", "Path to a file with one bytecode per line to measure in a single process, CSV rows are prefixed with the program index. A line can be label,bytecode, see -label")
+	dirPtr                 = flag.String("dir", "", "Path to a directory of .hex files (one bytecode each) measured as -batchFile, sorted by name, CSV rows are labeled with the file name. Files which can't be read are skipped with a warning")
+	codeHashPtr            = flag.Bool("codeHash", false, "If true, prepends a code_hash column to every CSV row (after the label, if any), and adds it to the JSON lines: the first 8 bytes (hex) of the keccak256 of the executed code, preludes included, to tell the code measured apart across datasets")
+	labelPtr               = flag.String("label", "", "Label of the program, prepended as a label column to every CSV row and added to the JSON lines, to join the results back to the source of the programs. In -batchFile, the default label of the lines without their own")
+
+	timeoutPtr = flag.Duration("timeout", 0, "If positive, every run, warm-up and measured, is cancelled once it runs longer than this duration (e.g. 10s). The sample of a program is skipped if a warm-up run times out, a measured run timing out has the status timeout and is left out of the run durations")
+	versionPtr = flag.Bool("version", false, "If true, will print the go-ethereum version the binary was built against and the build info, then exit")
+
+	seedPtr            = flag.Int64("seed", 1, "Seed of the source of any program generation done in the harness, printed with -printMeta")
+	reportHaltPtr      = flag.Bool("reportHalt", false, "If true, will print to STDERR how the first warm-up run halted: by STOP, RETURN, REVERT, SELFDESTRUCT or running past the end of the code")
+	continueOnErrorPtr = flag.Bool("continueOnError", false, "If true, measures the sample even if the warm-up run fails (reverts, runs out of gas etc.), otherwise stops with an error")
+	strictPtr          = flag.Bool("strict", false, "If true, fails before executing anything if the immediate of a PUSH runs past the end of the bytecode")
+	outFilePtr         = flag.String("outFile", "", "Path to a file the results (CSV, JSON) are appended to, in place of STDOUT. A Parquet file (-format parquet) is overwritten. A path ending in .gz implies -gzip")
+	gzipPtr            = flag.Bool("gzip", false, "If true, the results are compressed with gzip on the fly. Appending to a compressed -outFile adds a gzip member, which gzip readers read on as a single stream")
+	formatPtr          = flag.String("format", "csv", "Format of the results of -printCSV. Available options: csv, parquet (a columnar file with a typed column per CSV column, -printMeta lines are its key-value metadata, needs a binary built with -tags parquet)")
+	errFilePtr         = flag.String("errFile", "", "Path to a file the diagnostics are appended to, in place of STDERR")
+	quietPtr           = flag.Bool("quiet", false, "If true, suppresses the informational output to STDERR (warm-up and per-run lines, implies -printEach=false), errors and warnings are still printed")
+)
+
+// contractStorage collects the -storage flags, see measure.Environment Storage
+var contractStorage = make(storageFlag)
+
+// warmAccess collects the -warmAccess flags, see measure.Environment WarmAccessList
+var warmAccess accessListFlag
+
+// blockHashes collects the -blockHash flags, see measure.Environment BlockHashes
+var blockHashes = make(blockHashFlag)
+
+func init() {
+	flag.Var(&contractStorage, "storage", "Storage slot (hex key=value) preloaded into the executed contract before every execution, can be repeated. The access list is reset by every execution, so the first access to a slot is always cold (berlin and later)")
+	flag.Var(&warmAccess, "warmAccess", "Address (hex, or contract for the executed contract) or address=slot (hex) put into the access list before every execution, so that the first access is warm (berlin and later, or -extraEips 2929), can be repeated. Without it the first access is cold in every run, as the access list is reset by every execution")
+	flag.Var(&blockHashes, "blockHash", "Hash (32 bytes hex) returned by BLOCKHASH for the block number (decimal number=hash), can be repeated")
+}
@@ -1,216 +1,1466 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"math"
+	"io"
 	"math/big"
 	"os"
+	"path/filepath"
+	go_runtime "runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
-	"time"
-
-	_ "unsafe"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/rawdb"
-	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
-	"github.com/ethereum/go-ethereum/core/vm/runtime"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/imapp-pl/gas-cost-estimator/src/instrumentation_measurement/geth/measure"
+)
+
+// gitCommit of the measurement binary, set at build time with `-ldflags "-X main.gitCommit=$(git rev-parse HEAD)"`
+var gitCommit = "unknown"
+
+// stdout and stderr receive the results and the diagnostics respectively, see -outFile and -errFile.
+// info receives the informational diagnostics, silenced by -quiet
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+	info   io.Writer = os.Stderr
 )
 
-var calldata []byte
+// closers complete the outputs (the gzip stream, the Parquet footer), they are run in reverse order on return from main and by exit,
+// so that the outputs are complete even when exiting on an error
+var closers []func() error
+
+// closeOutputs runs the closers once, reporting the first failure
+func closeOutputs() error {
+	var failed error
+	for len(closers) > 0 {
+		closer := closers[len(closers)-1]
+		closers = closers[:len(closers)-1]
+		if err := closer(); err != nil && failed == nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// exit completes the outputs, see closers, and exits with the code, use it in place of os.Exit
+func exit(code int) {
+	if err := closeOutputs(); err != nil {
+		fmt.Fprintln(stderr, "Unable to write the output:", err)
+		code = 1
+	}
+	os.Exit(code)
+}
 
 func main() {
+	if err := parseCommandLine(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(2)
+	}
 
-	bytecodePtr := flag.String("bytecode", "", "EVM bytecode to execute and measure")
-	sampleSizePtr := flag.Int("sampleSize", 1, "Size of the sample - number of measured repetitions of execution")
-	printEachPtr := flag.Bool("printEach", true, "If false, printing of each execution time is skipped")
-	printCSVPtr := flag.Bool("printCSV", false, "If true, will print a CSV with standard results to STDOUT")
-	modePtr := flag.String("mode", "all", "Measurement mode. Available options: all, total, trace")
+	if *outFilePtr != "" {
+		openOutFile := openAppend
+		if *formatPtr == "parquet" {
+			// a Parquet file ends with its footer, it cannot be appended to
+			openOutFile = os.Create
+		}
+		outFile, err := openOutFile(*outFilePtr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to open output file:", err)
+			exit(1)
+		}
+		closers = append(closers, outFile.Close)
+		stdout = outFile
+	}
+	defer func() {
+		if err := closeOutputs(); err != nil {
+			fmt.Fprintln(stderr, "Unable to write the output:", err)
+			os.Exit(1)
+		}
+	}()
+	if *gzipPtr || strings.HasSuffix(*outFilePtr, ".gz") {
+		if *formatPtr == "parquet" {
+			fmt.Fprintln(stderr, "-gzip is not available with -format parquet, a file which can't be read compressed as a whole")
+			exit(1)
+		}
+		gzipOut := gzip.NewWriter(stdout)
+		closers = append(closers, gzipOut.Close)
+		stdout = gzipOut
+	}
+	if *errFilePtr != "" {
+		errFile, err := openAppend(*errFilePtr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to open diagnostics file:", err)
+			exit(1)
+		}
+		defer errFile.Close()
+		stderr = errFile
+		info = errFile
+	}
+	if *quietPtr {
+		info = io.Discard
+	}
 
-	flag.Parse()
+	if *envFilePtr != "" {
+		if err := applyEnvironment(*envFilePtr); err != nil {
+			fmt.Fprintln(stderr, "Invalid environment file:", err)
+			exit(1)
+		}
+	}
+
+	if *versionPtr {
+		fmt.Fprintln(stdout, "go-ethereum:", goEthereumVersion())
+		fmt.Fprintln(stdout, "gas-cost-estimator:", mainModuleVersion(), "git commit", gitCommit)
+		fmt.Fprintln(stdout, "go:", go_runtime.Version())
+		return
+	}
 
-	bytecode := common.Hex2Bytes(*bytecodePtr)
 	sampleSize := *sampleSizePtr
-	printEach := *printEachPtr
-	printCSV := *printCSVPtr
+	printEach := *printEachPtr && !*quietPtr
+	printCSV := *printCSVPtr || *formatPtr == "parquet"
 	mode := *modePtr
+	// the programs of a batch come from -batchFile or -dir
+	batch := *batchFilePtr != "" || *dirPtr != ""
 
-	if mode != "all" && mode != "total" && mode != "trace" {
-		fmt.Fprintln(os.Stderr, "Invalid measurement mode: ", mode)
-		os.Exit(1)
+	if err := validateFlags(mode, batch); err != nil {
+		fmt.Fprintln(stderr, err)
+		exit(1)
 	}
 
-	cfg := new(runtime.Config)
-	setDefaults(cfg)
-	// from `github.com/ethereum/go-ethereum/core/vm/runtime/runtime.go:109`
-	cfg.State, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	// mode stacksweep is mode opcode of the bytecode behind stack fills of a sweep of depths, see measure.StackFill
+	sweepStack := mode == "stacksweep"
+	if sweepStack {
+		mode = "opcode"
+	}
 
-	// Initialize some constant calldata of 32KB, 2^15 bytes.
-	// This means, if we offset between 0th and 2^14th byte, we can fetch between 0 and 2^14 bytes (16KB)
-	// In consequence, we need args to memory-copying OPCODEs to be between 0 and 2^14, 2^14 fits in a PUSH2,
-	// which we'll be using to generate arguments for those OPCODEs.
-	calldata = []byte(strings.Repeat("{", 1<<15))
+	// mode replay is mode all of the instructions at the pcs of a prior trace, see measure.ProgramOptions.MeasuredPcs
+	var measuredPcs map[uint64]vm.OpCode
+	if mode == "replay" {
+		traceFile, err := os.Open(*replayTracePtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Unable to read the trace:", err)
+			exit(1)
+		}
+		measuredPcs, err = measure.ReadTracePcs(traceFile)
+		traceFile.Close()
+		if err != nil {
+			fmt.Fprintf(stderr, "Invalid trace %v: %v\n", *replayTracePtr, err)
+			exit(1)
+		}
+		mode = "all"
+	}
 
-	// Warm-up. **NOTE** we're keeping tracing on during warm-up, otherwise measurements are off
-	cfg.EVMConfig.Debug = false
-	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
-	_, _, errWarmUp := runtime.Execute(bytecode, calldata, cfg)
-	// End warm-up
+	if mode == "noise" {
+		if *bytecodePtr != "" || *bytecodeFilePtr != "" {
+			fmt.Fprintln(stderr, "Warning: -mode noise measures a single STOP, the bytecode is not used")
+		}
+		if !isFlagSet("sampleSize") {
+			sampleSize = measure.DefaultNoiseSampleSize
+		}
+	}
 
-	for i := 0; i < sampleSize; i++ {
-		if mode == "all" {
-			MeasureAll(cfg, bytecode, printEach, printCSV, i)
-		} else if mode == "total" {
-			MeasureTotal(cfg, bytecode, printEach, printCSV, i)
-		} else if mode == "trace" {
-			TraceBytecode(cfg, bytecode, printCSV, i)
+	// the per-opcode rows are limited to the range, see measure.ProgramOptions.MeasuredRange
+	var measuredRange *measure.PcRange
+	if *measureRangePtr != "" {
+		var err error
+		measuredRange, err = parseRange(*measureRangePtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid measure range:", err)
+			exit(1)
 		}
 	}
-	if errWarmUp != nil {
-		fmt.Fprintln(os.Stderr, errWarmUp)
+
+	gcMode := *gcModePtr
+
+	if err := measure.SetTimer(*timerPtr); err != nil {
+		fmt.Fprintln(stderr, err)
+		exit(1)
+	}
+	measure.Seed(*seedPtr)
+	value, err := parseValue(*valuePtr)
+	if err != nil {
+		fmt.Fprintln(stderr, "Invalid value:", err)
+		exit(1)
+	}
+	env, err := flagEnvironment(value)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		exit(1)
+	}
+	if mode != "disasm" {
+		// printed so that the programs referencing their own address can be generated against it
+		fmt.Fprintln(info, "Contract address:", env.Address.Hex())
+	}
+	if mode != "disasm" && (*noncePtr != 0 || *createCollisionPtr) {
+		if *createCollisionPtr {
+			fmt.Fprintf(info, "Contract nonce: %d, CREATE address: %v, with code already\n", *noncePtr, env.CreateAddress().Hex())
+		} else {
+			fmt.Fprintf(info, "Contract nonce: %d, CREATE address: %v\n", *noncePtr, env.CreateAddress().Hex())
+		}
+	}
+
+	var programs [][]byte
+	var logDataSizes []int
+	// the inputs of the -precompile calls, of every program, and their sizes
+	var precompileAddress common.Address
+	var precompileInputs [][]byte
+	var precompileInputSizes []int
+	// the stack depths of mode stacksweep, of every program
+	var stackDepths []int
+	// the calldata sizes of -calldataSizes, of every program
+	var calldataSizes []int
+	// the labels of the programs, see -label
+	var labels []string
+	if batch {
+		var err error
+		if *dirPtr != "" {
+			programs, labels, err = readDir(*dirPtr)
+		} else {
+			programs, labels, err = readBatchFile(*batchFilePtr)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			exit(1)
+		}
+	} else if *precompilePtr != "" {
+		var err error
+		precompileAddress, err = measure.PrecompileAddress(*precompilePtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid precompile:", err)
+			exit(1)
+		}
+		input, err := decodeHex(*precompileInputPtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid precompile input:", err)
+			exit(1)
+		}
+		precompileInputs = [][]byte{input}
+		if *precompileInputSizePtr != "" {
+			precompileInputSizes, err = parseSizes(*precompileInputSizePtr)
+			if err != nil {
+				fmt.Fprintln(stderr, "Invalid precompile input size:", err)
+				exit(1)
+			}
+			precompileInputs = nil
+			for _, size := range precompileInputSizes {
+				if size < 0 {
+					fmt.Fprintln(stderr, "Invalid precompile input size:", size)
+					exit(1)
+				}
+				precompileInputs = append(precompileInputs, measure.PrecompileInput(input, size))
+			}
+		}
+		if *compareForkPtr != "" {
+			precompileInputs = append(precompileInputs, input)
+		}
+		// the programs are generated after the stack prelude, see measure.PrecompileCall
+		programs = make([][]byte, len(precompileInputs))
+	} else {
+		bytecodeHex := *bytecodePtr
+		var err error
+		if *bytecodeFilePtr != "" {
+			if bytecodeHex != "" {
+				fmt.Fprintln(stderr, "Warning: both -bytecode and -bytecodeFile given, using -bytecodeFile")
+			}
+			bytecodeHex, err = readBytecodeFile(*bytecodeFilePtr)
+		} else if bytecodeHex == "-" {
+			bytecodeHex, err = readBytecode(os.Stdin, "STDIN")
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			exit(1)
+		}
+
+		bytecode, err := decodeHex(bytecodeHex)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid bytecode:", err)
+			exit(1)
+		}
+		programs = [][]byte{bytecode}
+		if *logDataSizePtr != "" {
+			logDataSizes, err = parseSizes(*logDataSizePtr)
+			if err != nil {
+				fmt.Fprintln(stderr, "Invalid log data size:", err)
+				exit(1)
+			}
+			// the same bytecode once per size, the buffers are put in front of it after -repeatBytecode
+			for range logDataSizes[1:] {
+				programs = append(programs, bytecode)
+			}
+		}
+		if *calldataSizesPtr != "" {
+			calldataSizes, err = parseSizes(*calldataSizesPtr)
+			if err != nil {
+				fmt.Fprintln(stderr, "Invalid calldata size:", err)
+				exit(1)
+			}
+			for _, size := range calldataSizes {
+				if size < 0 {
+					fmt.Fprintln(stderr, "Invalid calldata size:", size)
+					exit(1)
+				}
+			}
+			// the same bytecode once per size, the calldata is sized after it is parsed
+			for range calldataSizes[1:] {
+				programs = append(programs, bytecode)
+			}
+		}
+		if sweepStack {
+			// the default depths start at the words of -stack, parsed with the stack prelude
+			minDepth := 0
+			if *stackPtr != "" {
+				minDepth = len(strings.Split(*stackPtr, ","))
+			}
+			stackDepths = measure.DefaultStackDepths(minDepth)
+			if *stackDepthPtr != "" {
+				stackDepths, err = parseSizes(*stackDepthPtr)
+				if err != nil {
+					fmt.Fprintln(stderr, "Invalid stack depth:", err)
+					exit(1)
+				}
+			}
+			// the same bytecode once per depth, the fills are put in front of it after the stack prelude
+			for range stackDepths[1:] {
+				programs = append(programs, bytecode)
+			}
+		}
+		if *compareForkPtr != "" {
+			// the same bytecode once more, under the rules of -compareFork
+			programs = append(programs, bytecode)
+		}
+
+		if *baselinePtr != "" {
+			baseline, err := decodeHex(*baselinePtr)
+			if err != nil {
+				fmt.Fprintln(stderr, "Invalid baseline bytecode:", err)
+				exit(1)
+			}
+			programs = append(programs, baseline)
+		}
+	}
+	// with more than one program, the output is tagged with the program index
+	multiProgram := batch || *baselinePtr != "" || *compareForkPtr != "" || *logDataSizePtr != "" || *precompileInputSizePtr != "" || sweepStack || *calldataSizesPtr != ""
+	// rows are tagged with the fork, the size or the depth in place of the program index, see -compareFork, -logDataSize,
+	// -precompileInputSize, mode stacksweep and -calldataSizes
+	var programTags []string
+	tagColumn := ""
+	if *compareForkPtr != "" {
+		programTags = []string{*forkPtr, *compareForkPtr}
+		tagColumn = "fork"
+	} else if *logDataSizePtr != "" {
+		for _, size := range logDataSizes {
+			programTags = append(programTags, strconv.Itoa(size))
+		}
+		tagColumn = "log_data_size"
+	} else if *precompileInputSizePtr != "" {
+		for _, size := range precompileInputSizes {
+			programTags = append(programTags, strconv.Itoa(size))
+		}
+		tagColumn = "precompile_input_size"
+	} else if sweepStack {
+		for _, depth := range stackDepths {
+			programTags = append(programTags, strconv.Itoa(depth))
+		}
+		tagColumn = "stack_depth"
+	} else if *calldataSizesPtr != "" {
+		for _, size := range calldataSizes {
+			programTags = append(programTags, strconv.Itoa(size))
+		}
+		tagColumn = "calldata_size"
+	} else if multiProgram {
+		tagColumn = "program_index"
+	}
+	// the label column is there if any of the programs has a label
+	labeled := false
+	if labels == nil {
+		labels = make([]string, len(programs))
+	}
+	for programId := range labels {
+		if labels[programId] == "" {
+			labels[programId] = *labelPtr
+		}
+		labeled = labeled || labels[programId] != ""
+	}
+	// rowPrefix is what the CSV rows of the program start with, its label, the hash of its code and its tag.
+	// It is taken once the preludes are in place
+	rowPrefix := func(programId int) string {
+		prefix := ""
+		if labeled {
+			prefix = measure.CSVField(labels[programId]) + ","
+		}
+		if *codeHashPtr {
+			prefix += measure.CodeHash(programs[programId]) + ","
+		}
+		if programId < len(programTags) {
+			prefix += measure.CSVField(programTags[programId]) + ","
+		} else if multiProgram {
+			prefix += fmt.Sprintf("%d,", programId)
+		}
+		return prefix
+	}
+	csvHeader := func(mode string, trace measure.TraceColumns, aggregate bool, epochs bool) string {
+		header := measure.CSVHeader(mode, trace, aggregate, tagColumn, epochs)
+		if *codeHashPtr {
+			header = "code_hash," + header
+		}
+		if labeled {
+			header = "label," + header
+		}
+		return header
 	}
-}
 
-func TraceBytecode(cfg *runtime.Config, bytecode []byte, printCSV bool, sampleId int) {
-	tracerConfig := new(vm.LogConfig)
-	setDefaultTracerConfig(tracerConfig)
+	// the -precompile call is repeated in place of the program, see measure.PrecompileCall
+	if *repeatBytecodePtr > 1 && *precompilePtr == "" {
+		for programId, bytecode := range programs {
+			programs[programId] = bytes.Repeat(bytecode, *repeatBytecodePtr)
+			if multiProgram {
+				fmt.Fprintf(info, "Effective bytecode length of program %d: %d\n", programId, len(programs[programId]))
+			} else {
+				fmt.Fprintln(info, "Effective bytecode length:", len(programs[programId]))
+			}
+		}
+	}
 
-	tracer := vm.NewStructLogger(tracerConfig)
-	cfg.EVMConfig.Tracer = tracer
-	cfg.EVMConfig.Debug = true
+	var prelude []byte
+	var stackWords []common.Hash
+	if *stackPtr != "" {
+		var err error
+		stackWords, err = parseStack(*stackPtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid stack:", err)
+			exit(1)
+		}
+		prelude, err = measure.StackPrelude(stackWords)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid stack:", err)
+			exit(1)
+		}
+		for programId, bytecode := range programs {
+			programs[programId] = append(append([]byte{}, prelude...), bytecode...)
+		}
+		fmt.Fprintf(info, "Stack prelude: %d PUSH32 instructions, %d bytes in front of the bytecode\n", len(stackWords), len(prelude))
+	}
+	if *preMemoryPtr != 0 {
+		// the memory prelude counts as part of the stack prelude, for the preludes and fills put around it
+		memoryPrelude, err := measure.MemoryPrelude(*preMemoryPtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid pre-expanded memory:", err)
+			exit(1)
+		}
+		for programId, bytecode := range programs {
+			programs[programId] = append(append(append([]byte{}, prelude...), memoryPrelude...), bytecode[len(prelude):]...)
+		}
+		prelude = append(prelude, memoryPrelude...)
+		fmt.Fprintf(info, "Memory prelude: %d words (%d bytes) pre-expanded, %d bytes in front of the bytecode\n", *preMemoryPtr, *preMemoryPtr*32, len(memoryPrelude))
+	}
+	if prelude != nil && measuredRange == nil && !sweepStack {
+		// the per-opcode rows are those of the bytecode behind the prelude, which is still executed and timed within the run durations
+		measuredRange = measure.RangeFrom(uint64(len(prelude)))
+		fmt.Fprintf(info, "Measuring the instructions from pc %d, past the prelude\n", measuredRange.Start)
+	}
+	if sweepStack {
+		// the fills go below the -stack words, which count towards the depth
+		maxFill := 0
+		for _, depth := range stackDepths {
+			if depth < len(stackWords) || depth > int(params.StackLimit) {
+				fmt.Fprintf(stderr, "Invalid stack depth: %d, from the %d words of -stack to %d\n", depth, len(stackWords), params.StackLimit)
+				exit(1)
+			}
+			if depth-len(stackWords) > maxFill {
+				maxFill = depth - len(stackWords)
+			}
+		}
+		var fill []byte
+		for programId, depth := range stackDepths {
+			fill = measure.StackFill(depth-len(stackWords), maxFill)
+			programs[programId] = append(fill, programs[programId]...)
+		}
+		// the rows are the instructions of the bytecode, behind the fill, which is of the same length for every depth, and the prelude
+		measuredRange, err = measure.CodeRange(programs[0], uint64(len(fill)+len(prelude)))
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid bytecode:", err)
+			exit(1)
+		}
+		fmt.Fprintf(info, "Stack fills: %d bytes in front of the stack prelude, measuring the instructions from pc %d\n", len(fill), measuredRange.Start)
+	}
+	for programId, size := range logDataSizes {
+		// after the stack prelude, which pushes the topics below the size and the offset
+		logPrelude, err := measure.LogDataPrelude(len(prelude), size)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid log data size:", err)
+			exit(1)
+		}
+		programs[programId] = append(append(append([]byte{}, prelude...), logPrelude...), programs[programId][len(prelude):]...)
+		fmt.Fprintf(info, "Log data prelude of program %d: %d bytes of memory, %d bytes in front of the bytecode\n", programId, size, len(logPrelude))
+	}
+	for programId, input := range precompileInputs {
+		call, err := measure.PrecompileCall(len(prelude), precompileAddress, input, *repeatBytecodePtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid precompile input:", err)
+			exit(1)
+		}
+		programs[programId] = append(append([]byte{}, prelude...), call...)
+	}
+	if *codePadPtr > 0 {
+		for programId, bytecode := range programs {
+			padded, err := measure.PadCode(bytecode, *codePadPtr)
+			if err != nil {
+				if multiProgram {
+					fmt.Fprintf(stderr, "Invalid code padding of program %d: %v\n", programId, err)
+				} else {
+					fmt.Fprintln(stderr, "Invalid code padding:", err)
+				}
+				exit(1)
+			}
+			programs[programId] = padded
+			if multiProgram {
+				fmt.Fprintf(info, "Effective code size (CODESIZE) of program %d: %d\n", programId, len(padded))
+			} else {
+				fmt.Fprintln(info, "Effective code size (CODESIZE):", len(padded))
+			}
+		}
+	}
+
+	if *strictPtr {
+		for programId, bytecode := range programs {
+			if err := measure.ValidatePushImmediates(bytecode); err != nil {
+				if multiProgram {
+					fmt.Fprintf(stderr, "Invalid program %d: %v\n", programId, err)
+				} else {
+					fmt.Fprintln(stderr, "Invalid bytecode:", err)
+				}
+				exit(1)
+			}
+		}
+	}
+
+	if measuredRange != nil {
+		for programId, bytecode := range programs {
+			if err := measuredRange.Validate(bytecode); err != nil {
+				if multiProgram {
+					fmt.Fprintf(stderr, "Invalid measure range of program %d: %v\n", programId, err)
+				} else {
+					fmt.Fprintln(stderr, "Invalid measure range:", err)
+				}
+				exit(1)
+			}
+		}
+	}
+
+	if measuredPcs != nil {
+		for programId, bytecode := range programs {
+			if err := measure.ValidatePcs(bytecode, measuredPcs); err != nil {
+				if multiProgram {
+					fmt.Fprintf(stderr, "Invalid trace of program %d: %v\n", programId, err)
+				} else {
+					fmt.Fprintln(stderr, "Invalid trace:", err)
+				}
+				exit(1)
+			}
+		}
+		fmt.Fprintf(info, "Replaying the %d instructions of the trace\n", len(measuredPcs))
+	}
+
+	if mode == "disasm" {
+		// only decode the programs, nothing is executed
+		if *csvHeaderPtr {
+			fmt.Fprintln(stdout, csvHeader(mode, measure.TraceColumns{}, false, false))
+		}
+		for programId, bytecode := range programs {
+			out := stdout
+			if prefix := rowPrefix(programId); prefix != "" {
+				out = measure.NewCSVPrefixWriter(stdout, prefix)
+			}
+			if err := measure.Disassemble(out, bytecode); err != nil {
+				fmt.Fprintln(stderr, "Invalid bytecode:", err)
+				exit(1)
+			}
+		}
+		return
+	}
 
-	_, _, err := runtime.Execute(bytecode, calldata, cfg)
+	chainConfig, err := measure.ChainConfigForFork(*forkPtr)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(stderr, err)
+		exit(1)
+	}
+	var compareChainConfig *params.ChainConfig
+	if *compareForkPtr != "" {
+		compareChainConfig, err = measure.ChainConfigForFork(*compareForkPtr)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			exit(1)
+		}
+	}
+	for programId, input := range precompileInputs {
+		config, fork := chainConfig, *forkPtr
+		if programId > 0 && compareChainConfig != nil {
+			config, fork = compareChainConfig, *compareForkPtr
+		}
+		gas, err := measure.PrecompileGas(config, new(big.Int).SetUint64(*blockNumberPtr), precompileAddress, input)
+		if err != nil {
+			fmt.Fprintf(stderr, "Invalid precompile: %v %v\n", err, fork)
+			exit(1)
+		}
+		if multiProgram {
+			fmt.Fprintf(info, "Precompile call of program %d: input of %d bytes, %d gas charged by the precompile\n", programId, len(input), gas)
+		} else {
+			fmt.Fprintf(info, "Precompile call: input of %d bytes, %d gas charged by the precompile\n", len(input), gas)
+		}
+	}
+	// the access list is kept since berlin, or with EIP-2929 enabled on top of an earlier fork
+	eip2929 := false
+	for _, eip := range env.ExtraEips {
+		eip2929 = eip2929 || eip == 2929
+	}
+	for _, config := range []*params.ChainConfig{chainConfig, compareChainConfig} {
+		if config != nil && len(warmAccess) > 0 && !eip2929 && !config.IsBerlin(new(big.Int).SetUint64(*blockNumberPtr)) {
+			fmt.Fprintln(stderr, "-warmAccess requires an access list, i.e. the fork berlin or later, or -extraEips 2929")
+			exit(1)
+		}
+	}
+	var origin common.Address
+	if *callerPtr != "" {
+		origin, err = parseAddress(*callerPtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid caller:", err)
+			exit(1)
+		}
+	}
+	block := measure.Block{Number: new(big.Int).SetUint64(*blockNumberPtr)}
+	if *timePtr != "" {
+		block.Time, err = parseValue(*timePtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid time:", err)
+			exit(1)
+		}
+	}
+	block.Difficulty, err = parseValue(*difficultyPtr)
+	if err != nil {
+		fmt.Fprintln(stderr, "Invalid difficulty:", err)
+		exit(1)
+	}
+	if *baseFeePtr != "" {
+		block.BaseFee, err = parseValue(*baseFeePtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid base fee:", err)
+			exit(1)
+		}
+		if !chainConfig.IsLondon(block.Number) {
+			fmt.Fprintln(stderr, "Warning: -baseFee ignored, the fork", *forkPtr, "has no base fee")
+		}
+	}
+	var initCode []byte
+	if *deployPtr != "" {
+		initCode, err = decodeHex(*deployPtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid deploy bytecode:", err)
+			exit(1)
+		}
+	}
+	newConfig := func(chainConfig *params.ChainConfig) *measure.Config {
+		cfg, deployedAddress, err := measure.NewConfig(chainConfig, *gasLimitPtr, value, origin, block, env, initCode)
+		if err != nil {
+			fmt.Fprintln(stderr, "Deployment failed:", err)
+			exit(1)
+		}
+		cfg.Stderr, cfg.Info = stderr, info
+		if initCode != nil {
+			fmt.Fprintln(info, "Deployed contract address:", deployedAddress.Hex())
+		}
+		return cfg
+	}
+	// the config printed by -printConfig is handed to the first caller, rather than deployed once more
+	var printedConfig *measure.Config
+	var printedConfigLock sync.Mutex
+	// every worker gets its own config and state, see runWorkers
+	newWorkerConfig := func() *measure.Config {
+		printedConfigLock.Lock()
+		defer printedConfigLock.Unlock()
+		if cfg := printedConfig; cfg != nil {
+			printedConfig = nil
+			return cfg
+		}
+		return newConfig(chainConfig)
+	}
+	if *printConfigPtr {
+		printedConfig = newConfig(chainConfig)
+		if err := measure.WriteConfig(stderr, *forkPtr, printedConfig); err != nil {
+			fmt.Fprintln(stderr, "Unable to print the config:", err)
+			exit(1)
+		}
+	}
+
+	calldata := measure.DefaultCalldata()
+	if isFlagSet("calldata") {
+		var err error
+		calldata, err = decodeHex(*calldataPtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid calldata:", err)
+			exit(1)
+		}
+	}
+	if *initCodePtr != "" {
+		var err error
+		calldata, err = decodeHex(*initCodePtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid init code:", err)
+			exit(1)
+		}
+	}
+	// the calldata of every program, see -calldataSizes
+	programCalldata := make([][]byte, len(programs))
+	for programId := range programs {
+		programCalldata[programId] = calldata
+	}
+	for programId, size := range calldataSizes {
+		programCalldata[programId] = measure.CalldataOfSize(calldata, size)
+		fmt.Fprintf(info, "Calldata of program %d: %d bytes\n", programId, len(programCalldata[programId]))
 	}
 
-	if printCSV {
-		logs := tracer.StructLogs()
-		for i, log := range logs {
-			fmt.Fprintf(os.Stdout, "%d,%d,%v,%d", i, log.Pc, log.Op, len(log.Stack))
+	trace := measure.TraceColumns{
+		StackColumns: *traceStackDepthPtr,
+		Memory:       *traceMemoryPtr,
+		MemoryLimit:  *traceMemoryLimitPtr,
+		OpNumeric:    *traceOpNumericPtr,
+		Branch:       *traceBranchPtr,
+		Storage:      *traceStoragePtr || *traceStorageDeltaPtr,
+		StorageDelta: *traceStorageDeltaPtr,
+	}
+	if *formatPtr == "parquet" {
+		parquetOut, err := newParquetWriter(stdout, csvHeader(mode, trace, *aggregatePtr, *epochsPtr > 1))
+		if err != nil {
+			fmt.Fprintln(stderr, "Unable to write Parquet:", err)
+			exit(1)
+		}
+		stdout = parquetOut
+		closers = append(closers, parquetOut.Close)
+	}
+
+	if *printMetaPtr {
+		writeMeta(stdout, *seedPtr)
+	}
+	if mode == "cycles" {
+		tscFrequency := measure.EstimateTSCFrequency()
+		fmt.Fprintf(info, "Estimated TSC frequency: %.0f Hz\n", tscFrequency)
+		if *printMetaPtr {
+			fmt.Fprintf(stdout, "# tsc_frequency_hz=%.0f\n", tscFrequency)
+		}
+	}
 
-			// printing the stack
-			for i, elem := range log.Stack {
-				if i < 1024 {
-					fmt.Fprintf(os.Stdout, ",%d", elem.ToBig())
+	// the folded stacks of mode flamegraph have no header
+	if *csvHeaderPtr && printCSV && mode != "traceJSON" && mode != "flamegraph" {
+		fmt.Fprintln(stdout, csvHeader(mode, trace, *aggregatePtr, *epochsPtr > 1))
+	}
+
+	if mode == "verify" {
+		// a single untimed run of every program, none of the timing is needed
+		cfg := newWorkerConfig()
+		mismatches := 0
+		for programId, bytecode := range programs {
+			out, name := stdout, ""
+			if prefix := rowPrefix(programId); prefix != "" {
+				out = measure.NewCSVPrefixWriter(stdout, prefix)
+			}
+			if multiProgram {
+				name = fmt.Sprintf("Program %d: ", programId)
+			}
+			gasUsed, err := measure.GasUsed(cfg, bytecode, programCalldata[programId])
+			if err != nil {
+				fmt.Fprintf(stderr, "%sExecution failed: %v\n", name, err)
+			}
+			if printCSV {
+				fmt.Fprintf(out, "%d,%d,%v\n", gasUsed, *expectGasPtr, measure.ErrorStatus(err))
+			}
+			if gasUsed != uint64(*expectGasPtr) {
+				fmt.Fprintf(stderr, "%sGas mismatch, used: %d, expected: %d\n", name, gasUsed, *expectGasPtr)
+				mismatches++
+			} else {
+				fmt.Fprintf(info, "%sGas used as expected: %d\n", name, gasUsed)
+			}
+		}
+		if mismatches > 0 {
+			fmt.Fprintf(stderr, "Gas mismatch in %d of %d programs\n", mismatches, len(programs))
+			exit(1)
+		}
+		return
+	}
+
+	if mode == "noise" {
+		// the empty program, timed as in mode total, the floor of every measurement
+		stats, err := measure.Calibrate(newWorkerConfig(), calldata, "total", false, *warmupPtr, sampleSize, gcMode)
+		if err != nil {
+			fmt.Fprintln(stderr, "Unable to measure the noise:", err)
+			exit(1)
+		}
+		if printCSV {
+			out := stdout
+			if prefix := rowPrefix(0); prefix != "" {
+				out = measure.NewCSVPrefixWriter(stdout, prefix)
+			}
+			measure.WriteNoiseCSV(out, stats)
+		}
+		// the verdict is printed even with -quiet, a noisy environment fails, e.g. to gate a measurement job
+		if !measure.WriteNoiseSummary(stderr, stats) {
+			exit(1)
+		}
+		return
+	}
+
+	var resultFile *os.File
+	if *resultCSVPtr != "" {
+		resultFile, err = os.Create(*resultCSVPtr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Unable to create result CSV file:", err)
+			exit(1)
+		}
+		defer resultFile.Close()
+	}
+
+	var metrics *runMetrics
+	if *metricsAddrPtr != "" {
+		metrics = newRunMetrics()
+		serveMetrics(*metricsAddrPtr, metrics)
+	}
+
+	// the options shared by all the programs, the served ones included
+	programOptions := measure.ProgramOptions{
+		Warmup:          *warmupPtr,
+		Timeout:         *timeoutPtr,
+		ReportWarmUp:    *reportHaltPtr || *initCodePtr != "",
+		ContinueOnError: *continueOnErrorPtr,
+		TargetSEM:       *targetSEMPtr,
+		MaxSamples:      *maxSamplesPtr,
+		Epochs:          *epochsPtr,
+		EpochPause:      *epochPausePtr,
+		GCMode:          gcMode,
+		PrintEach:       printEach,
+		PrintCSV:        printCSV,
+		Aggregate:       *aggregatePtr,
+		Summary:         *summaryPtr,
+		Trace:           trace,
+		MeasuredRange:   measuredRange,
+		MeasuredPcs:     measuredPcs,
+	}
+	if metrics != nil {
+		programOptions.RunObserver = metrics.observeRun
+	}
+	measureProgram := func(cfg *measure.Config, programId int, bytecode []byte, stdout io.Writer, resultSink io.Writer) (*measure.DurationStats, error) {
+		out, results := stdout, resultSink
+		if prefix := rowPrefix(programId); prefix != "" {
+			// every CSV row is tagged with the label and the index of the program it comes from
+			out = measure.NewCSVPrefixWriter(stdout, prefix)
+			if results != nil {
+				results = measure.NewCSVPrefixWriter(resultSink, prefix)
+			}
+		}
+		var jsonOut *measure.JSONWriter
+		if *printJSONPtr {
+			if multiProgram {
+				jsonOut = measure.NewJSONWriter(stdout, &programId)
+			} else {
+				jsonOut = measure.NewJSONWriter(stdout, nil)
+			}
+			if labeled {
+				jsonOut = jsonOut.WithLabel(labels[programId])
+			}
+			if *codeHashPtr {
+				jsonOut = jsonOut.WithCodeHash(measure.CodeHash(bytecode))
+			}
+		}
+		opts := programOptions
+		opts.Mode, opts.ReuseEVM, opts.SampleSize = mode, *reuseEVMPtr, sampleSize
+		opts.Out, opts.Results, opts.JSON = out, results, jsonOut
+		stats, err := measure.MeasureProgram(cfg, bytecode, programCalldata[programId], opts)
+		if err != nil && programId < len(programTags) {
+			err = fmt.Errorf("%v %v: %w", strings.ReplaceAll(tagColumn, "_", " "), programTags[programId], err)
+		} else if err != nil && multiProgram {
+			err = fmt.Errorf("program %d: %w", programId, err)
+		}
+		if metrics != nil {
+			metrics.observeProgram(err)
+		}
+		return stats, err
+	}
+
+	var resultSink io.Writer
+	if resultFile != nil {
+		resultSink = resultFile
+	}
+	if *servePtr != "" {
+		prepare := func(bytecode []byte) ([]byte, error) {
+			bytecode = append(append([]byte{}, prelude...), bytes.Repeat(bytecode, *repeatBytecodePtr)...)
+			bytecode, err := measure.PadCode(bytecode, *codePadPtr)
+			if err != nil {
+				return bytecode, err
+			}
+			if *strictPtr {
+				if err := measure.ValidatePushImmediates(bytecode); err != nil {
+					return bytecode, err
+				}
+			}
+			if measuredRange != nil {
+				return bytecode, measuredRange.Validate(bytecode)
+			}
+			return bytecode, nil
+		}
+		resolve := func(request servedRequest) (servedProgram, error) {
+			program := servedProgram{calldata: calldata, sampleSize: sampleSize, mode: mode, fork: *forkPtr, label: *labelPtr}
+			bytecode, err := decodeHex(strings.TrimSpace(request.Bytecode))
+			if err == nil {
+				program.bytecode, err = prepare(bytecode)
+			}
+			if err != nil {
+				return program, fmt.Errorf("invalid bytecode: %v", err)
+			}
+			if request.Calldata != nil {
+				if program.calldata, err = decodeHex(*request.Calldata); err != nil {
+					return program, fmt.Errorf("invalid calldata: %v", err)
+				}
+			}
+			if request.SampleSize < 0 {
+				return program, fmt.Errorf("invalid sample size: %d", request.SampleSize)
+			} else if request.SampleSize > 0 {
+				program.sampleSize = request.SampleSize
+			}
+			if request.Mode != "" {
+				if !servedMode(request.Mode) || (request.Mode == "traceJSON" && !*printJSONPtr) {
+					return program, fmt.Errorf("invalid measurement mode: %v", request.Mode)
 				}
+				program.mode = request.Mode
 			}
-			// if there are not 32 elems, append the csv with empty columns
-			for i := len(log.Stack); i < 32; i++ {
-				fmt.Fprintf(os.Stdout, ",")
+			if request.Fork != "" {
+				if _, err := measure.ChainConfigForFork(request.Fork); err != nil {
+					return program, err
+				}
+				program.fork = request.Fork
+			}
+			if request.Label != "" {
+				if err := validateLabel(request.Label); err != nil {
+					return program, fmt.Errorf("invalid label: %v", err)
+				}
+				program.label = request.Label
+			}
+			return program, nil
+		}
+		newServedConfig := func(fork string) *measure.Config {
+			chainConfig, _ := measure.ChainConfigForFork(fork)
+			return newConfig(chainConfig)
+		}
+		measureServed := func(cfg *measure.Config, program servedProgram, stdout io.Writer) (*measure.DurationStats, error) {
+			out, results := stdout, resultSink
+			var jsonOut *measure.JSONWriter
+			if *printJSONPtr {
+				jsonOut = measure.NewJSONWriter(stdout, nil)
 			}
-			fmt.Fprintf(os.Stdout, "\n")
+			prefix := ""
+			if program.label != "" {
+				prefix = measure.CSVField(program.label) + ","
+				jsonOut = jsonOut.WithLabel(program.label)
+			}
+			if *codeHashPtr {
+				prefix += measure.CodeHash(program.bytecode) + ","
+				jsonOut = jsonOut.WithCodeHash(measure.CodeHash(program.bytecode))
+			}
+			if prefix != "" {
+				out = measure.NewCSVPrefixWriter(stdout, prefix)
+				if results != nil {
+					results = measure.NewCSVPrefixWriter(resultSink, prefix)
+				}
+			}
+			reuseEVM := *reuseEVMPtr && (program.mode == "all" || program.mode == "total")
+			opts := programOptions
+			opts.Mode, opts.ReuseEVM, opts.SampleSize = program.mode, reuseEVM, program.sampleSize
+			opts.Out, opts.Results, opts.JSON = out, results, jsonOut
+			stats, err := measure.MeasureProgram(cfg, program.bytecode, program.calldata, opts)
+			if metrics != nil {
+				metrics.observeProgram(err)
+			}
+			return stats, err
 		}
+		err := serve(*servePtr, *workersPtr, *cpuPtr, resolve, newServedConfig, measureServed)
+		fmt.Fprintln(stderr, "Measurement server failed:", err)
+		exit(1)
+	}
+	if *workersPtr > 1 {
+		if err := runWorkers(*workersPtr, *cpuPtr, programs, newWorkerConfig, measureProgram, stdout, resultSink); err != nil {
+			exitOnWarmUpError(err)
+		}
+		return
+	}
+
+	// Keep warm-up and all samples on a single OS thread, optionally pinned to a single CPU,
+	// as migrations between cores cause bimodal timings
+	pinThread(*cpuPtr)
+	if *calibratePtr {
+		calibration, err := measure.Calibrate(newWorkerConfig(), calldata, mode, *reuseEVMPtr, *warmupPtr, sampleSize, gcMode)
+		if err != nil {
+			fmt.Fprintln(stderr, "Calibration failed:", err)
+			exit(1)
+		}
+		programOptions.HarnessOverhead = calibration.Mean()
+		fmt.Fprintf(stderr, "Calibration: harness overhead of %v, the mean duration of %d runs of an empty program (STOP)\n",
+			calibration.Mean(), calibration.Count())
+	}
+	cfg := newWorkerConfig()
+	var stats []*measure.DurationStats
+	for programId, bytecode := range programs {
+		if programId > 0 && compareChainConfig != nil {
+			// an environment identical but for the rules
+			cfg = newConfig(compareChainConfig)
+		}
+		programStats, err := measureProgram(cfg, programId, bytecode, stdout, resultSink)
+		if err != nil {
+			exitOnWarmUpError(err)
+		}
+		stats = append(stats, programStats)
+	}
+	if *baselinePtr != "" {
+		measure.WriteBaselineComparison(stderr, stats[0], stats[1])
+	}
+	if *compareForkPtr != "" {
+		measure.WriteForkComparison(stderr, *forkPtr, stats[0], *compareForkPtr, stats[1])
 	}
 }
 
-func MeasureTotal(cfg *runtime.Config, bytecode []byte, printEach bool, printCSV bool, sampleId int) {
-	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+// exitOnWarmUpError reports the failed warm-up run of measure.MeasureProgram and exits
+func exitOnWarmUpError(err error) {
+	fmt.Fprintln(stderr, "Stopping,", err, "(use -continueOnError to measure failing programs anyway)")
+	exit(1)
+}
 
-	// We're not collecting in between runs anymore. If the pressure on memory is OK, this has been chosen as the best approach.
-	// (Assuming GOGC=off, which is well enough aligned with default go GC behavior).
-	// go_runtime.GC()
+// openAppend opens the file for appending, creating it if needed
+func openAppend(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
 
-	_, _, err := runtime.Execute(bytecode, calldata, cfg)
+// pinThread locks the calling goroutine to its OS thread and, if cpu is not negative, pins that thread to the CPU
+func pinThread(cpu int) {
+	go_runtime.LockOSThread()
+	if cpu >= 0 {
+		if err := setCPUAffinity(cpu); err != nil {
+			fmt.Fprintln(stderr, "Warning: unable to pin to CPU, continuing unpinned:", err)
+		}
+	}
+}
+
+// writeMeta writes # commented lines describing the host and the build, so that measurements from different machines can be told apart
+func writeMeta(out io.Writer, seed int64) {
+	fmt.Fprintf(out, "# go_version=%v\n", go_runtime.Version())
+	fmt.Fprintf(out, "# gomaxprocs=%v\n", go_runtime.GOMAXPROCS(0))
+	fmt.Fprintf(out, "# num_cpu=%v\n", go_runtime.NumCPU())
+	fmt.Fprintf(out, "# cpu_model=%v\n", cpuModel())
+	fmt.Fprintf(out, "# git_commit=%v\n", gitCommit)
+	fmt.Fprintf(out, "# go_ethereum_version=%v\n", goEthereumVersion())
+	fmt.Fprintf(out, "# seed=%v\n", seed)
+}
+
+// goEthereumVersion is the version of the go-ethereum module the binary was built against, along with its replacement, if any
+func goEthereumVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != "github.com/ethereum/go-ethereum" {
+			continue
+		}
+		if dep.Replace != nil {
+			// e.g. the instrumented fork, replacing the upstream version with a local directory, which has no version
+			return strings.TrimSpace(fmt.Sprintf("%v => %v %v", dep.Version, dep.Replace.Path, dep.Replace.Version))
+		}
+		return dep.Version
+	}
+	return "unknown"
+}
 
+// mainModuleVersion is the version of the gas-cost-estimator module, (devel) unless built from a tagged module
+func mainModuleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	return info.Main.Version
+}
+
+// cpuModel reads the CPU model name from /proc/cpuinfo, which is available on Linux only
+func cpuModel() string {
+	cpuinfo, err := os.ReadFile("/proc/cpuinfo")
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		return "unknown"
+	}
+	for _, line := range strings.Split(string(cpuinfo), "\n") {
+		if strings.HasPrefix(line, "model name") {
+			if i := strings.Index(line, ":"); i >= 0 {
+				return strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	return "unknown"
+}
+
+// readStateFile reads the accounts of -stateFile, in the format of the alloc of a genesis file
+func readStateFile(path string) (core.GenesisAlloc, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
 
-	if printCSV {
-		vm.WriteCSVInstrumentationTotal(os.Stdout, cfg.EVMConfig.Instrumenter, sampleId)
+	var snapshot core.GenesisAlloc
+	if err := json.NewDecoder(file).Decode(&snapshot); err != nil {
+		return nil, err
 	}
+	return snapshot, nil
 }
 
-func MeasureAll(cfg *runtime.Config, bytecode []byte, printEach bool, printCSV bool, sampleId int) {
-	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+// readBatchFile reads a file with one hex-encoded program per line, skipping blank lines and # comments.
+// A line label,bytecode gives the program its label, up to the last comma, the labels of the other programs are empty
+func readBatchFile(path string) ([][]byte, []string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to read batch file: %v", err)
+	}
+	defer file.Close()
 
-	// see above
-	// go_runtime.GC()
+	var programs [][]byte
+	var labels []string
+	scanner := bufio.NewScanner(file)
+	// programs can be much longer than the default 64KB line limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<26)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		label := ""
+		if comma := strings.LastIndexByte(line, ','); comma >= 0 {
+			label, line = strings.TrimSpace(line[:comma]), strings.TrimSpace(line[comma+1:])
+			if err := validateLabel(label); err != nil {
+				return nil, nil, fmt.Errorf("Invalid label in %v line %d: %v", path, lineNumber, err)
+			}
+		}
+		bytecode, err := decodeHex(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid bytecode in %v line %d: %v", path, lineNumber, err)
+		}
+		programs = append(programs, bytecode)
+		labels = append(labels, label)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("Unable to read batch file: %v", err)
+	}
+	return programs, labels, nil
+}
 
-	start := time.Now()
-	_, _, err := runtime.Execute(bytecode, calldata, cfg)
-	duration := time.Since(start)
+// readDir reads every .hex file of the directory, sorted by name, as a program labeled with the file name, see readBytecodeFile.
+// The files which can't be read or decoded are skipped with a warning, failing only if no program is left
+func readDir(path string) ([][]byte, []string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to read directory: %v", err)
+	}
+	var programs [][]byte
+	var labels []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".hex" {
+			continue
+		}
+		bytecodeHex, err := readBytecodeFile(filepath.Join(path, entry.Name()))
+		var bytecode []byte
+		if err == nil {
+			bytecode, err = decodeHex(bytecodeHex)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Warning: skipping %v: %v\n", entry.Name(), err)
+			continue
+		}
+		programs = append(programs, bytecode)
+		labels = append(labels, entry.Name())
+	}
+	if len(programs) == 0 {
+		return nil, nil, fmt.Errorf("No readable .hex files in %v", path)
+	}
+	return programs, labels, nil
+}
+
+// validateLabel rejects labels which would split the CSV rows they are prepended to over lines, see -label.
+// Commas and quotes are escaped, see measure.CSVField
+func validateLabel(label string) error {
+	if strings.ContainsAny(label, "\r\n") {
+		return fmt.Errorf("%q contains a line break", label)
+	}
+	return nil
+}
+
+// isFlagSet tells if the flag was explicitly given on the command line, or set from -envFile
+func isFlagSet(name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
+// storageFlag collects repeated key=value storage slot flags
+type storageFlag map[common.Hash]common.Hash
+
+func (f storageFlag) String() string {
+	slots := make([]string, 0, len(f))
+	for key, value := range f {
+		slots = append(slots, key.Hex()+"="+value.Hex())
+	}
+	sort.Strings(slots)
+	return strings.Join(slots, ",")
+}
+
+func (f storageFlag) Set(slot string) error {
+	keyValue := strings.SplitN(slot, "=", 2)
+	if len(keyValue) != 2 {
+		return fmt.Errorf("storage slot must be given as key=value, got %v", slot)
+	}
+	key, err := parseWord(keyValue[0])
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		return fmt.Errorf("invalid storage key: %v", err)
 	}
-	if printEach {
-		fmt.Fprintln(os.Stderr, "Run duration:", duration)
+	value, err := parseWord(keyValue[1])
+	if err != nil {
+		return fmt.Errorf("invalid storage value: %v", err)
+	}
+	f[key] = value
+	return nil
+}
+
+// accessListEntry is an address or address=slot access list flag, a nil address stands for the executed contract
+type accessListEntry struct {
+	address *common.Address
+	slot    *common.Hash
+}
 
-		instrumenterLogs := cfg.EVMConfig.Instrumenter.Logs
-		vm.WriteInstrumentation(os.Stderr, instrumenterLogs)
+// accessListFlag collects repeated address or address=slot access list flags.
+// The executed contract is resolved only in accessList, as its address may be set by a later flag, see -address
+type accessListFlag []accessListEntry
+
+func (f *accessListFlag) String() string {
+	entries := make([]string, 0, len(*f))
+	for _, entry := range *f {
+		address := "contract"
+		if entry.address != nil {
+			address = entry.address.Hex()
+		}
+		if entry.slot != nil {
+			address += "=" + entry.slot.Hex()
+		}
+		entries = append(entries, address)
 	}
+	return strings.Join(entries, ",")
+}
 
-	if printCSV {
-		instrumenterLogs := cfg.EVMConfig.Instrumenter.Logs
-		vm.WriteCSVInstrumentationAll(os.Stdout, instrumenterLogs, sampleId)
+func (f *accessListFlag) Set(entry string) error {
+	addressSlot := strings.SplitN(entry, "=", 2)
+	var parsed accessListEntry
+	if addressSlot[0] != "contract" {
+		address, err := parseAddress(addressSlot[0])
+		if err != nil {
+			return fmt.Errorf("invalid access list address: %v", err)
+		}
+		parsed.address = &address
 	}
+	if len(addressSlot) == 2 {
+		slot, err := parseWord(addressSlot[1])
+		if err != nil {
+			return fmt.Errorf("invalid access list slot: %v", err)
+		}
+		parsed.slot = &slot
+	}
+	*f = append(*f, parsed)
+	return nil
 }
 
-// copied directly from github.com/ethereum/go-ethereum/core/vm/runtime/runtime.go
-// so that we skip this in measured code
-func setDefaults(cfg *runtime.Config) {
-	if cfg.ChainConfig == nil {
-		cfg.ChainConfig = &params.ChainConfig{
-			ChainID:             big.NewInt(1),
-			HomesteadBlock:      new(big.Int),
-			DAOForkBlock:        new(big.Int),
-			DAOForkSupport:      false,
-			EIP150Block:         new(big.Int),
-			EIP150Hash:          common.Hash{},
-			EIP155Block:         new(big.Int),
-			EIP158Block:         new(big.Int),
-			ByzantiumBlock:      new(big.Int),
-			ConstantinopleBlock: new(big.Int),
-			PetersburgBlock:     new(big.Int),
-			IstanbulBlock:       new(big.Int),
-			MuirGlacierBlock:    new(big.Int),
-			BerlinBlock:         new(big.Int),
-			LondonBlock:         new(big.Int),
+// accessList returns the collected entries, with the executed contract at the given address
+func (f accessListFlag) accessList(contract common.Address) types.AccessList {
+	list := make(types.AccessList, 0, len(f))
+	for _, entry := range f {
+		tuple := types.AccessTuple{Address: contract}
+		if entry.address != nil {
+			tuple.Address = *entry.address
+		}
+		if entry.slot != nil {
+			tuple.StorageKeys = []common.Hash{*entry.slot}
 		}
+		list = append(list, tuple)
 	}
+	return list
+}
 
-	if cfg.Difficulty == nil {
-		cfg.Difficulty = new(big.Int)
+// blockHashFlag collects repeated number=hash block hash flags
+type blockHashFlag map[uint64]common.Hash
+
+func (f blockHashFlag) String() string {
+	hashes := make([]string, 0, len(f))
+	for number, hash := range f {
+		hashes = append(hashes, strconv.FormatUint(number, 10)+"="+hash.Hex())
 	}
-	if cfg.Time == nil {
-		cfg.Time = big.NewInt(time.Now().Unix())
+	sort.Strings(hashes)
+	return strings.Join(hashes, ",")
+}
+
+func (f blockHashFlag) Set(blockHash string) error {
+	numberHash := strings.SplitN(blockHash, "=", 2)
+	if len(numberHash) != 2 {
+		return fmt.Errorf("block hash must be given as number=hash, got %v", blockHash)
+	}
+	number, err := strconv.ParseUint(numberHash[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid block number: %v", err)
+	}
+	hash, err := decodeHex(numberHash[1])
+	if err != nil {
+		return fmt.Errorf("invalid block hash: %v", err)
+	}
+	if len(hash) != common.HashLength {
+		return fmt.Errorf("block hash must be %d bytes long, got %d", common.HashLength, len(hash))
+	}
+	f[number] = common.BytesToHash(hash)
+	return nil
+}
+
+// parseWord parses a hex-encoded word of at most 32 bytes, left-padding it with zeros
+func parseWord(wordHex string) (common.Hash, error) {
+	word, err := decodeHex(wordHex)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if len(word) > common.HashLength {
+		return common.Hash{}, fmt.Errorf("word must be at most %d bytes long, got %d", common.HashLength, len(word))
 	}
-	if cfg.GasLimit == 0 {
-		cfg.GasLimit = math.MaxUint64
+	return common.BytesToHash(word), nil
+}
+
+// parseRange parses a range X:Y of pcs, see measure.PcRange
+func parseRange(rangeString string) (*measure.PcRange, error) {
+	ends := strings.Split(rangeString, ":")
+	if len(ends) != 2 {
+		return nil, fmt.Errorf("range must be X:Y, got %v", rangeString)
 	}
-	if cfg.GasPrice == nil {
-		cfg.GasPrice = new(big.Int)
+	start, err := strconv.ParseUint(strings.TrimSpace(ends[0]), 0, 64)
+	if err != nil {
+		return nil, err
 	}
-	if cfg.Value == nil {
-		cfg.Value = new(big.Int)
+	end, err := strconv.ParseUint(strings.TrimSpace(ends[1]), 0, 64)
+	if err != nil {
+		return nil, err
 	}
-	if cfg.BlockNumber == nil {
-		cfg.BlockNumber = new(big.Int)
+	return &measure.PcRange{Start: start, End: end}, nil
+}
+
+// parseSizes parses the comma-separated sizes of -logDataSize and -precompileInputSize
+func parseSizes(sizesString string) ([]int, error) {
+	var sizes []int
+	for _, sizeString := range strings.Split(sizesString, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(sizeString))
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
 	}
-	if cfg.GetHashFn == nil {
-		cfg.GetHashFn = func(n uint64) common.Hash {
-			return common.BytesToHash(crypto.Keccak256([]byte(new(big.Int).SetUint64(n).String())))
+	return sizes, nil
+}
+
+// parseStack parses comma-separated stack words, bottom to top
+func parseStack(stackHex string) ([]common.Hash, error) {
+	var words []common.Hash
+	for _, wordHex := range strings.Split(stackHex, ",") {
+		word, err := parseWord(strings.TrimSpace(wordHex))
+		if err != nil {
+			return nil, err
 		}
+		words = append(words, word)
+	}
+	return words, nil
+}
+
+// parseValue parses a non-negative wei amount given in decimal or 0x-prefixed hex
+func parseValue(valueString string) (*big.Int, error) {
+	value, ok := new(big.Int).SetString(valueString, 0)
+	if !ok {
+		return nil, fmt.Errorf("not a decimal or hex number: %v", valueString)
+	}
+	if value.Sign() < 0 {
+		return nil, fmt.Errorf("negative value: %v", valueString)
+	}
+	return value, nil
+}
+
+// parseAddress parses a hex-encoded 20 byte address
+func parseAddress(addressHex string) (common.Address, error) {
+	address, err := decodeHex(addressHex)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if len(address) != common.AddressLength {
+		return common.Address{}, fmt.Errorf("address must be %d bytes long, got %d", common.AddressLength, len(address))
+	}
+	return common.BytesToAddress(address), nil
+}
+
+// readBytecodeFile reads hex-encoded bytecode from path, see readBytecode
+func readBytecodeFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read bytecode file: %v", err)
 	}
+	defer file.Close()
+	return readBytecode(file, path)
 }
 
-// for full options see github.com/ethereum/go-ethereum/core/vm/logger.go:50
-func setDefaultTracerConfig(cfg *vm.LogConfig) {
-	// Necessary, otherwise the initial memory allocation causes that memory to get copied over each instruction.
-	// If we need this to be enabled in the future, we need to rethink the initial MSTORE8
-	cfg.EnableMemory = false
-	cfg.DisableStack = false
-	cfg.DisableStorage = true
-	cfg.EnableReturnData = true
-	cfg.Debug = false
-	cfg.Limit = 0
+// readBytecode reads hex-encoded bytecode until EOF, stripping surrounding whitespace and a leading 0x
+func readBytecode(reader io.Reader, source string) (string, error) {
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read bytecode from %v: %v", source, err)
+	}
+	bytecodeHex := trimHexPrefix(strings.TrimSpace(string(contents)))
+	if bytecodeHex == "" {
+		return "", fmt.Errorf("Bytecode read from %v is empty", source)
+	}
+	return bytecodeHex, nil
+}
+
+// trimHexPrefix strips an optional leading 0x or 0X
+func trimHexPrefix(hexString string) string {
+	if strings.HasPrefix(hexString, "0x") || strings.HasPrefix(hexString, "0X") {
+		return hexString[2:]
+	}
+	return hexString
 }
 
-// runtimeNano returns the current value of the runtime clock in nanoseconds.
-//go:linkname runtimeNano runtime.nanotime
-func runtimeNano() int64
+// decodeHex is a strict replacement for common.Hex2Bytes, which silently decodes malformed input into garbage
+func decodeHex(hexString string) ([]byte, error) {
+	hexString = trimHexPrefix(hexString)
+	if len(hexString)%2 != 0 {
+		return nil, fmt.Errorf("odd length %d of hex string", len(hexString))
+	}
+	decoded, err := hex.DecodeString(hexString)
+	if err != nil {
+		if invalidByte, ok := err.(hex.InvalidByteError); ok {
+			return nil, fmt.Errorf("non-hex character %q at position %d", byte(invalidByte), strings.IndexByte(hexString, byte(invalidByte)))
+		}
+		return nil, err
+	}
+	return decoded, nil
+}
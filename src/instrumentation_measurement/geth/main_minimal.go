@@ -1,3 +1,8 @@
+// Minimal version of main.go, measuring in total mode only. Run it on its own with `go run main_minimal.go`.
+
+//go:build ignore
+// +build ignore
+
 package main
 
 import (
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+// TestSubcommandFlags checks that every flag taken by a subcommand is defined, once, see parseCommandLine
+func TestSubcommandFlags(t *testing.T) {
+	for _, name := range subcommandNames() {
+		seen := make(map[string]bool)
+		for _, group := range subcommands[name].flags {
+			for _, flagName := range group {
+				if flag.CommandLine.Lookup(flagName) == nil {
+					t.Errorf("%v: undefined flag -%v", name, flagName)
+				}
+				if seen[flagName] {
+					t.Errorf("%v: flag -%v taken twice", name, flagName)
+				}
+				seen[flagName] = true
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package measure
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// opcodeAggregate is the number of executions and the summed instrumenter measurement of an opcode within a run
+type opcodeAggregate struct {
+	count  int
+	timeNs int64
+}
+
+// writeCSVAggregate writes a row per distinct opcode of the logs: sampleId, op, count, summed and mean measure_all_time_ns,
+// sorted by the opcode byte
+func writeCSVAggregate(out io.Writer, logs []vm.InstrumenterLog, sampleId int) {
+	aggregates := make(map[vm.OpCode]*opcodeAggregate)
+	for _, log := range logs {
+		aggregate, ok := aggregates[log.Op]
+		if !ok {
+			aggregate = new(opcodeAggregate)
+			aggregates[log.Op] = aggregate
+		}
+		aggregate.count++
+		aggregate.timeNs += log.TimeNs
+	}
+
+	sorted := make([]vm.OpCode, 0, len(aggregates))
+	for op := range aggregates {
+		sorted = append(sorted, op)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, op := range sorted {
+		aggregate := aggregates[op]
+		fmt.Fprintf(out, "%d,%v,%d,%d,%.2f\n", sampleId, CSVField(op.String()), aggregate.count, aggregate.timeNs, float64(aggregate.timeNs)/float64(aggregate.count))
+	}
+}
@@ -0,0 +1,36 @@
+package measure
+
+import (
+	"fmt"
+	"time"
+	_ "unsafe"
+)
+
+// nanotime is the clock used to time executions, see SetTimer
+var nanotime = runtimeNano
+
+// SetTimer selects the clock used to time executions. Available options: runtimeNano, time (fallback to time.Since)
+func SetTimer(name string) error {
+	switch name {
+	case "runtimeNano":
+		nanotime = runtimeNano
+	case "time":
+		nanotime = timeSinceNano
+	default:
+		return fmt.Errorf("Invalid timer: %v", name)
+	}
+	return nil
+}
+
+// timeStart is the reference point of timeSinceNano
+var timeStart = time.Now()
+
+// timeSinceNano is the fallback for runtimeNano, going through the monotonic clock reading of time.Since
+func timeSinceNano() int64 {
+	return int64(time.Since(timeStart))
+}
+
+// runtimeNano returns the current value of the runtime clock in nanoseconds.
+//
+//go:linkname runtimeNano runtime.nanotime
+func runtimeNano() int64
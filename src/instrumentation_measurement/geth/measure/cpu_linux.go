@@ -0,0 +1,19 @@
+//go:build linux
+// +build linux
+
+package measure
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// currentCPU returns the logical CPU the calling OS thread is running on, or -1 if unknown
+func currentCPU() int {
+	var cpu uint32
+	if _, _, errno := unix.RawSyscall(unix.SYS_GETCPU, uintptr(unsafe.Pointer(&cpu)), 0, 0); errno != 0 {
+		return -1
+	}
+	return int(cpu)
+}
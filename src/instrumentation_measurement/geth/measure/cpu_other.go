@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package measure
+
+// currentCPU is only supported on Linux, elsewhere the CPU is unknown
+func currentCPU() int {
+	return -1
+}
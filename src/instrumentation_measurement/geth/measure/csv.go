@@ -0,0 +1,75 @@
+package measure
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// CSVField returns the string field escaped for a CSV row: quoted, its quotes doubled, if it contains a comma, a quote
+// or a line break, or starts with a space, as encoding/csv does. Every string field of the rows goes through it
+func CSVField(field string) string {
+	if field == "" || (!strings.ContainsAny(field, ",\"\r\n") && field[0] != ' ' && field[0] != '\t') {
+		return field
+	}
+	return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+}
+
+// csvPrefixWriter prepends a fixed prefix to every line written through it,
+// so that rows emitted by the vm CSV writers can be tagged with extra columns
+type csvPrefixWriter struct {
+	writer  io.Writer
+	prefix  string
+	midLine bool
+}
+
+func (w *csvPrefixWriter) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		if !w.midLine {
+			if _, err := io.WriteString(w.writer, w.prefix); err != nil {
+				return written, err
+			}
+		}
+		end := len(p)
+		if i := bytes.IndexByte(p[written:], '\n'); i >= 0 {
+			end = written + i + 1
+		}
+		if _, err := w.writer.Write(p[written:end]); err != nil {
+			return written, err
+		}
+		w.midLine = p[end-1] != '\n'
+		written = end
+	}
+	return len(p), nil
+}
+
+// NewCSVPrefixWriter tags every line written through it with the prefix, e.g. the index of the program in batch mode
+func NewCSVPrefixWriter(writer io.Writer, prefix string) io.Writer {
+	return &csvPrefixWriter{writer: writer, prefix: prefix}
+}
+
+// csvSuffixWriter appends a fixed suffix to every line written through it, so that the rows of writeResultCSV
+// can be extended with columns known for the whole sample
+type csvSuffixWriter struct {
+	writer io.Writer
+	suffix string
+}
+
+func (w *csvSuffixWriter) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		end := bytes.IndexByte(p[written:], '\n')
+		if end < 0 {
+			n, err := w.writer.Write(p[written:])
+			return written + n, err
+		}
+		end += written
+		if _, err := w.writer.Write(p[written:end]); err != nil {
+			return written, err
+		}
+		if _, err := io.WriteString(w.writer, w.suffix+"\n"); err != nil {
+			return end, err
+		}
+		written = end + 1
+	}
+	return len(p), nil
+}
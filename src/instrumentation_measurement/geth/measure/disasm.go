@@ -0,0 +1,40 @@
+package measure
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// Disassemble writes a row per instruction of the bytecode: pc, op and the immediate of PUSH (hex), without executing it.
+// Fails on a PUSH which immediate runs past the end of the bytecode, after writing the preceding instructions
+func Disassemble(out io.Writer, bytecode []byte) error {
+	it := asm.NewInstructionIterator(bytecode)
+	for it.Next() {
+		if it.Arg() != nil {
+			fmt.Fprintf(out, "%d,%v,%#x\n", it.PC(), CSVField(it.Op().String()), it.Arg())
+		} else {
+			fmt.Fprintf(out, "%d,%v,\n", it.PC(), CSVField(it.Op().String()))
+		}
+	}
+	return it.Error()
+}
+
+// ValidatePushImmediates fails if the immediate of a PUSH runs past the end of the bytecode,
+// as the EVM silently pads such immediate with zeros
+func ValidatePushImmediates(bytecode []byte) error {
+	for pc := 0; pc < len(bytecode); pc++ {
+		op := vm.OpCode(bytecode[pc])
+		if !op.IsPush() {
+			continue
+		}
+		size := int(op-vm.PUSH1) + 1
+		if available := len(bytecode) - pc - 1; available < size {
+			return fmt.Errorf("%v at pc %d expects %d immediate bytes, only %d available", op, pc, size, available)
+		}
+		pc += size
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package measure
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytecode []byte
+		expected string
+		fails    bool
+	}{
+		{"empty", nil, "", false},
+		{"push and add", []byte{0x60, 0x01, 0x61, 0x02, 0x03, 0x01, 0x00}, "0,PUSH1,0x01\n2,PUSH2,0x0203\n5,ADD,\n6,STOP,\n", false},
+		{"unassigned opcode", []byte{0x0c, 0x00}, "0,opcode 0xc not defined,\n1,STOP,\n", false},
+		{"truncated push", []byte{0x00, 0x61, 0x01}, "0,STOP,\n", true},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := Disassemble(&out, test.bytecode)
+		if out.String() != test.expected {
+			t.Errorf("%v: rows\n%v\nexpected\n%v", test.name, out.String(), test.expected)
+		}
+		if (err != nil) != test.fails {
+			t.Errorf("%v: error %v", test.name, err)
+		}
+		if errPush := ValidatePushImmediates(test.bytecode); (errPush != nil) != test.fails {
+			t.Errorf("%v: validation error %v", test.name, errPush)
+		}
+	}
+}
@@ -0,0 +1,604 @@
+package measure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// DefaultContractAddress is the address the measured bytecode is executed at by default, same as in runtime.Execute
+var DefaultContractAddress = common.BytesToAddress([]byte("contract"))
+
+// Environment is the state every execution of a measurement starts from, on top of the empty state, see NewConfig.
+// Zero values leave the settings out, but Address, which stands for DefaultContractAddress then
+type Environment struct {
+	// Address the measured bytecode is executed at
+	Address common.Address
+	// SenderBalance, if not nil, is the balance of the caller every execution starts from, see prepareState. If nil, a caller sending
+	// value is given 2^128 wei on top of its balance once
+	SenderBalance *big.Int
+	// Nonce is the nonce the contract starts every execution with, which the address of the contract created by its first
+	// CREATE derives from, see CreateAddress
+	Nonce uint64
+	// CreateCollision puts code at CreateAddress, so that the first CREATE of the contract collides with an existing contract
+	CreateCollision bool
+	// WarmAccessList holds the addresses and storage slots put into the access list at the start of every execution (Berlin and later),
+	// so that the first access to them is warm already
+	WarmAccessList types.AccessList
+	// Storage holds the storage slots preloaded into the contract before every execution
+	Storage map[common.Hash]common.Hash
+	// PreimageRecording and ExtraEips are the toggles of vm.Config of the fork which change the interpreter. With PreimageRecording
+	// KECCAK256 records the preimage of every hash in the state. ExtraEips are enabled on top of the rules of the fork, see vm.ValidEip,
+	// the interpreter copies its jump table to enable them whenever an EVM is created
+	PreimageRecording bool
+	ExtraEips         []int
+	// StateSnapshot holds accounts (balance, nonce, code, storage) installed into the state before the measurement, e.g. exported
+	// from a real node, so that BALANCE, EXTCODESIZE, SLOAD etc. read populated trie nodes instead of an empty database
+	StateSnapshot core.GenesisAlloc
+	// BlockHashes are returned by BLOCKHASH for the given block numbers, in place of the default hashes of blockHash.
+	// BLOCKHASH only looks up the 256 blocks preceding the current block number, returning zero for all the others
+	BlockHashes map[uint64]common.Hash
+	// HashSeed, if not nil, seeds the default hashes of BLOCKHASH, see blockHash
+	HashSeed *uint64
+}
+
+// CreateAddress is the address of the contract created by the first CREATE of an execution
+func (env Environment) CreateAddress() common.Address {
+	return crypto.CreateAddress(env.Address, env.Nonce)
+}
+
+// Config is the runtime config and the state prepared by NewConfig, shared by all the executions of a measurement,
+// along with the environment they start from and the writers of their diagnostics. A config is used by a single measurement
+// at a time, every worker has its own
+type Config struct {
+	*runtime.Config
+	Env Environment
+	// Stderr receives the diagnostics, e.g. execution errors and warnings, os.Stderr by default
+	Stderr io.Writer
+	// Info receives the informational diagnostics, e.g. warm-up notes and per-run lines, which can be silenced on their own,
+	// os.Stderr by default
+	Info io.Writer
+	// timeout, if positive, cancels every execution running longer, set by MeasureProgram, see ProgramOptions.Timeout
+	timeout time.Duration
+}
+
+// hasAccessList tells if the executions of cfg charge for cold and warm accesses (EIP-2929), since Berlin or enabled by ExtraEips,
+// in which case the access list is prepared along with the state, see prepareState
+func hasAccessList(cfg *Config, rules params.Rules) bool {
+	if rules.IsBerlin {
+		return true
+	}
+	for _, eip := range cfg.EVMConfig.ExtraEips {
+		if eip == 2929 {
+			return true
+		}
+	}
+	return false
+}
+
+// blockHash is the default hash of the block: the keccak256 of the decimal block number, or with a seed, the keccak256 of the seed
+// and the block number (8 bytes big-endian each), so that the hashes are reproducible from the seed alone, whatever computes them
+func blockHash(seed *uint64, n uint64) common.Hash {
+	if seed == nil {
+		return common.BytesToHash(crypto.Keccak256([]byte(new(big.Int).SetUint64(n).String())))
+	}
+	var preimage [16]byte
+	binary.BigEndian.PutUint64(preimage[:8], *seed)
+	binary.BigEndian.PutUint64(preimage[8:], n)
+	return common.BytesToHash(crypto.Keccak256(preimage[:]))
+}
+
+// Block is the context read by NUMBER, TIMESTAMP, DIFFICULTY and BASEFEE, nil fields keep the defaults of setDefaults
+type Block struct {
+	// Number of the block, 0 by default
+	Number *big.Int
+	// Time of the block in seconds since the epoch, the current time by default
+	Time *big.Int
+	// Difficulty of the block, 0 by default
+	Difficulty *big.Int
+	// BaseFee of the block, params.InitialBaseFee by default. Only taken since London, which introduced BASEFEE
+	BaseFee *big.Int
+}
+
+// NewConfig prepares the config and state for execution in the environment, deploying the initCode contract, if given
+func NewConfig(chainConfig *params.ChainConfig, gasLimit uint64, value *big.Int, origin common.Address, block Block, env Environment, initCode []byte) (*Config, common.Address, error) {
+	if env.Address == (common.Address{}) {
+		env.Address = DefaultContractAddress
+	}
+	cfg := &Config{Config: new(runtime.Config), Env: env, Stderr: os.Stderr, Info: os.Stderr}
+	cfg.ChainConfig = chainConfig
+	cfg.GasLimit = gasLimit
+	cfg.Value = value
+	cfg.Origin = origin
+	cfg.BlockNumber = block.Number
+	cfg.Time = block.Time
+	cfg.Difficulty = block.Difficulty
+	cfg.GetHashFn = func(n uint64) common.Hash {
+		if hash, ok := env.BlockHashes[n]; ok {
+			return hash
+		}
+		return blockHash(env.HashSeed, n)
+	}
+	setDefaults(cfg.Config)
+	cfg.EVMConfig.EnablePreimageRecording = env.PreimageRecording
+	// the interpreter drops the EIPs it fails to enable from the slice
+	cfg.EVMConfig.ExtraEips = append([]int(nil), env.ExtraEips...)
+	if block.BaseFee != nil && cfg.ChainConfig.IsLondon(cfg.BlockNumber) {
+		cfg.BaseFee = block.BaseFee
+	}
+	// from `github.com/ethereum/go-ethereum/core/vm/runtime/runtime.go:109`
+	database := state.NewDatabase(rawdb.NewMemoryDatabase())
+	cfg.State, _ = state.New(common.Hash{}, database, nil)
+	if len(env.StateSnapshot) > 0 {
+		var err error
+		if cfg.State, err = installSnapshot(cfg.State, database, env.StateSnapshot); err != nil {
+			return nil, common.Address{}, err
+		}
+	}
+	if env.CreateCollision {
+		cfg.State.SetCode(env.CreateAddress(), []byte{byte(vm.STOP)})
+	}
+	if env.SenderBalance != nil {
+		cfg.State.SetBalance(cfg.Origin, env.SenderBalance)
+	} else if cfg.Value.Sign() > 0 {
+		// every execution transfers the value from the caller, so make sure it never runs out of funds
+		cfg.State.AddBalance(cfg.Origin, new(big.Int).Lsh(big.NewInt(1), 128))
+	}
+
+	var deployedAddress common.Address
+	if initCode != nil {
+		var err error
+		deployedAddress, err = deploy(cfg, initCode)
+		if err != nil {
+			return nil, common.Address{}, err
+		}
+	}
+	return cfg, deployedAddress, nil
+}
+
+// installSnapshot writes the snapshot into the state and commits it into the trie, returning the state reopened at its root,
+// so that the accounts are read through the trie rather than from the state objects cached by the writes
+func installSnapshot(statedb *state.StateDB, database state.Database, snapshot core.GenesisAlloc) (*state.StateDB, error) {
+	for address, account := range snapshot {
+		statedb.SetBalance(address, account.Balance)
+		statedb.SetNonce(address, account.Nonce)
+		statedb.SetCode(address, account.Code)
+		for key, value := range account.Storage {
+			statedb.SetState(address, key, value)
+		}
+	}
+	root, err := statedb.Commit(false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to commit the state snapshot: %v", err)
+	}
+	if err := database.TrieDB().Commit(root, false, nil); err != nil {
+		return nil, fmt.Errorf("unable to commit the state snapshot: %v", err)
+	}
+	return state.New(root, database, nil)
+}
+
+// prepareState is the setup of github.com/ethereum/go-ethereum/core/vm/runtime/runtime.go Execute, done once for all the executions
+// of the bytecode rather than before every one of them: the access list (Berlin and later), the contract account (re)created at the
+// address of the environment with its nonce, the bytecode and the preloaded storage, and the balance of the caller.
+// The executions revert their changes to the state, see execute, so that all of them start from the state prepared here
+func prepareState(cfg *Config, bytecode []byte) {
+	address := cfg.Env.Address
+	// runtime.NewEnv leaves the random of the block context out
+	if rules := cfg.ChainConfig.Rules(cfg.BlockNumber, false); hasAccessList(cfg, rules) {
+		cfg.State.PrepareAccessList(cfg.Origin, &address, vm.ActivePrecompiles(rules), cfg.Env.WarmAccessList)
+	}
+	cfg.State.CreateAccount(address)
+	cfg.State.SetNonce(address, cfg.Env.Nonce)
+	// set the receiver's (the executing contract) code for execution.
+	cfg.State.SetCode(address, bytecode)
+	for key, value := range cfg.Env.Storage {
+		cfg.State.SetState(address, key, value)
+	}
+	if cfg.Env.SenderBalance != nil {
+		cfg.State.SetBalance(cfg.Origin, cfg.Env.SenderBalance)
+	}
+}
+
+// execute is the call of github.com/ethereum/go-ethereum/core/vm/runtime/runtime.go Execute, to the contract prepared by prepareState.
+// The changes to the state are reverted once it is done, so that e.g. a contract created by one execution does not collide with
+// the same contract created by the next one. Returns the leftover gas in place of the state.
+func execute(calldata []byte, cfg *Config) ([]byte, uint64, error) {
+	ret, leftOverGas, _, _, err := executeTimed(calldata, cfg)
+	return ret, leftOverGas, err
+}
+
+// executeTimed is execute returning the duration of the call alone, the EVM is created before and the state reverted after it,
+// and the gas refund of the call, read before the state is reverted. The refund counter of the state is not reset in between runs,
+// as there is no transaction to finalize, so the refund of the call is the difference of the counter after and before it, see refundSince
+func executeTimed(calldata []byte, cfg *Config) ([]byte, uint64, time.Duration, uint64, error) {
+	return callTimed(runtime.NewEnv(cfg.Config), calldata, cfg)
+}
+
+// callTimed is the call of executeTimed on the given EVM, which may be reused across calls, see reusableExecution
+func callTimed(vmenv *vm.EVM, calldata []byte, cfg *Config) ([]byte, uint64, time.Duration, uint64, error) {
+	snapshot := cfg.State.Snapshot()
+	defer cfg.State.RevertToSnapshot(snapshot)
+	refundBefore := cfg.State.GetRefund()
+	timedOut := startTimeout(vmenv, cfg.timeout)
+
+	start := nanotime()
+	// Call the code with the given configuration.
+	ret, leftOverGas, err := vmenv.Call(
+		vm.AccountRef(cfg.Origin),
+		cfg.Env.Address,
+		calldata,
+		cfg.GasLimit,
+		cfg.Value,
+	)
+	duration := time.Duration(nanotime() - start)
+	if timedOut() {
+		err = ErrTimeout
+	}
+	return ret, leftOverGas, duration, refundSince(cfg, refundBefore), err
+}
+
+// refundSince is the refund added to the counter of the state since it read refundBefore. A call may lower the counter as well,
+// e.g. by resetting a slot a previous run cleared, and then it refunds nothing, rather than wrapping the difference around
+func refundSince(cfg *Config, refundBefore uint64) uint64 {
+	refund := cfg.State.GetRefund()
+	if refund < refundBefore {
+		return 0
+	}
+	return refund - refundBefore
+}
+
+// cappedRefund is the part of the refund of a call actually returned by a transaction using the gas: at most a half of it,
+// or a fifth since London (EIP-3529)
+func cappedRefund(cfg *Config, refund uint64, leftOverGas uint64) uint64 {
+	quotient := params.RefundQuotient
+	if cfg.ChainConfig.IsLondon(cfg.BlockNumber) {
+		quotient = params.RefundQuotientEIP3529
+	}
+	if limit := (cfg.GasLimit - leftOverGas) / quotient; refund > limit {
+		return limit
+	}
+	return refund
+}
+
+// resetInstrumenter clears the instrumenter in cfg for another run, truncating its logs in place and zeroing its total, as a new one
+// starts, or sets a new one if there is none yet. Reusing the buffer across the sample spares allocating and growing the logs during every timed run, as the buffer has
+// grown to the length of the program already in the warm-up. The logs of the previous run are overwritten, so they must be
+// written out before
+func resetInstrumenter(cfg *Config) {
+	instrumenter := cfg.EVMConfig.Instrumenter
+	if instrumenter == nil {
+		cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+		return
+	}
+	instrumenter.Logs = instrumenter.Logs[:0]
+	instrumenter.TotalExecutionDuration = 0
+}
+
+// executeTracingHalt runs execute with a haltTracer, to report how the execution halted and the contracts it created.
+// The tracer sees every opcode, so this run is considerably slower and must not be measured
+func executeTracingHalt(cfg *Config, calldata []byte) ([]byte, *haltTracer, error) {
+	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+	tracer := new(haltTracer)
+	cfg.EVMConfig.Tracer = tracer
+	cfg.EVMConfig.Debug = true
+	defer func() {
+		cfg.EVMConfig.Tracer = nil
+		cfg.EVMConfig.Debug = false
+	}()
+
+	ret, _, err := execute(calldata, cfg)
+	return ret, tracer, err
+}
+
+// deploy runs the creation bytecode, leaving the created contract in cfg.State for the measured executions
+func deploy(cfg *Config, initCode []byte) (common.Address, error) {
+	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+	_, address, _, err := runtime.Create(initCode, cfg.Config)
+	return address, err
+}
+
+// printExecutionError reports an execution error to stderr, if any, along with the return data and revert reason.
+// Running out of gas is reported explicitly, as the instrumentation printed for such run is partial
+func printExecutionError(stderr io.Writer, ret []byte, err error) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, vm.ErrOutOfGas) {
+		fmt.Fprintln(stderr, "Execution ran out of gas, instrumentation covers the executed part only")
+	} else {
+		fmt.Fprintln(stderr, err)
+	}
+	if len(ret) > 0 {
+		fmt.Fprintln(stderr, "Return data:", hexutil.Encode(ret))
+		if reason, errUnpack := abi.UnpackRevert(ret); errUnpack == nil {
+			fmt.Fprintln(stderr, "Revert reason:", reason)
+		}
+	}
+}
+
+// warnIfStateDependent warns if the first measured run returned differently than the last warm-up run, i.e. the program depends
+// on the state left by previous runs (e.g. contracts it created or balances it transferred), so that the measured runs may take
+// a different path than the warm-up
+func warnIfStateDependent(cfg *Config, retWarmUp []byte, errWarmUp error, ret []byte, err error) {
+
+	// errors are compared by their messages, as some of them are created anew by every run
+	if !bytes.Equal(ret, retWarmUp) || fmt.Sprint(err) != fmt.Sprint(errWarmUp) {
+		fmt.Fprintln(cfg.Stderr, "Warning: the measured runs return differently than the warm-up, the program depends on the state left by previous runs")
+		fmt.Fprintf(cfg.Stderr, "Warm-up: %v, return data: %s\n", errWarmUp, hexutil.Encode(retWarmUp))
+		fmt.Fprintf(cfg.Stderr, "Measured: %v, return data: %s\n", err, hexutil.Encode(ret))
+	}
+}
+
+// writeTimeoutCSV writes a row with the status timeout in place of the results of a sample skipped after its warm-up timed out,
+// -1 standing for the warm-up run, with no memory expansions nor call depth recorded, see recordMemory
+func writeTimeoutCSV(results io.Writer, startUnixNs int64) {
+	if results == nil {
+		return
+	}
+	fmt.Fprintf(results, "-1,timeout,0,0,%d,%d,timeout,0,0,0,0,0\n", currentCPU(), startUnixNs)
+}
+
+// ErrorStatus classifies the error of an execution, for the status column of the result CSV and the JSON lines: ok if there
+// is none, error if it is none of the errors of the interpreter
+func ErrorStatus(err error) string {
+	var underflow *vm.ErrStackUnderflow
+	var overflow *vm.ErrStackOverflow
+	var invalidOpCode *vm.ErrInvalidOpCode
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, vm.ErrOutOfGas):
+		return "out_of_gas"
+	case errors.Is(err, vm.ErrCodeStoreOutOfGas):
+		return "code_store_out_of_gas"
+	case errors.Is(err, vm.ErrExecutionReverted):
+		return "revert"
+	case errors.As(err, &underflow):
+		return "stack_underflow"
+	case errors.As(err, &overflow):
+		return "stack_overflow"
+	case errors.As(err, &invalidOpCode):
+		return "invalid_opcode"
+	case errors.Is(err, vm.ErrInvalidJump):
+		return "invalid_jump"
+	case errors.Is(err, vm.ErrDepth):
+		return "call_depth"
+	case errors.Is(err, vm.ErrInsufficientBalance):
+		return "insufficient_balance"
+	case errors.Is(err, vm.ErrContractAddressCollision):
+		return "address_collision"
+	case errors.Is(err, vm.ErrMaxCodeSizeExceeded):
+		return "max_code_size"
+	case errors.Is(err, vm.ErrInvalidCode):
+		return "invalid_code"
+	case errors.Is(err, vm.ErrWriteProtection):
+		return "write_protection"
+	case errors.Is(err, vm.ErrReturnDataOutOfBounds):
+		return "return_data_out_of_bounds"
+	case errors.Is(err, vm.ErrGasUintOverflow):
+		return "gas_uint_overflow"
+	case errors.Is(err, vm.ErrNonceUintOverflow):
+		return "nonce_uint_overflow"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	}
+	return "error"
+}
+
+// writeResultCSV writes a row with the sampleId, whether the run succeeded, the length of the return data
+// and the number of executed opcodes, counted by the instrumenter or tracer of the mode, so that results can be normalized by it.
+// Then the logical CPU the run ended on (-1 if unknown, see currentCPU), to tell whether the pinning took effect,
+// and the wall-clock time the run started at, in Unix nanoseconds, to line the runs up with external CPU telemetry.
+// Last the class of the error of the run, see ErrorStatus, and the gas it used (the gas limit less the gas left over) and the gas left over,
+// as returned by the call, with no intrinsic gas of a transaction and before the refund
+func writeResultCSV(results io.Writer, sampleId int, startUnixNs int64, ret []byte, err error, opcodes int, gasLimit uint64, leftOverGas uint64) {
+	if results == nil {
+		return
+	}
+	fmt.Fprintf(results, "%d,%t,%d,%d,%d,%d,%v,%d,%d\n", sampleId, err == nil, len(ret), opcodes, currentCPU(), startUnixNs, ErrorStatus(err),
+		gasLimit-leftOverGas, leftOverGas)
+}
+
+// effectiveConfig is the config the runs are executed with, once the defaults are filled in, see WriteConfig
+type effectiveConfig struct {
+	Fork            string                      `json:"fork"`
+	ChainId         *big.Int                    `json:"chainId"`
+	GasLimit        uint64                      `json:"gasLimit"`
+	GasPrice        *big.Int                    `json:"gasPrice"`
+	Value           *big.Int                    `json:"value"`
+	Caller          common.Address              `json:"caller"`
+	CallerBalance   *big.Int                    `json:"callerBalance"`
+	Address         common.Address              `json:"address"`
+	Nonce           uint64                      `json:"nonce"`
+	CreateAddress   common.Address              `json:"createAddress"`
+	CreateCollision bool                        `json:"createCollision"`
+	Coinbase        common.Address              `json:"coinbase"`
+	BlockNumber     *big.Int                    `json:"blockNumber"`
+	Time            *big.Int                    `json:"time"`
+	Difficulty      *big.Int                    `json:"difficulty"`
+	BaseFee         *big.Int                    `json:"baseFee,omitempty"`
+	Storage         map[common.Hash]common.Hash `json:"storage"`
+	WarmAccessList  types.AccessList            `json:"warmAccessList"`
+	BlockHashes     map[uint64]common.Hash      `json:"blockHashes"`
+	HashSeed        *uint64                     `json:"hashSeed,omitempty"`
+	StateAccounts   int                         `json:"stateAccounts"`
+	// PreimageRecording and ExtraEips are the toggles of vm.Config
+	PreimageRecording bool  `json:"preimageRecording"`
+	ExtraEips         []int `json:"extraEips"`
+}
+
+// WriteConfig writes the config of cfg, as resolved by NewConfig, with the defaults of setDefaults, as a JSON line,
+// along with its environment (address, storage, access list etc.), so that a dataset can be told what it was produced
+// under. The base fee is omitted before London, which has none
+func WriteConfig(out io.Writer, fork string, cfg *Config) error {
+	config := effectiveConfig{
+		Fork:            fork,
+		ChainId:         cfg.ChainConfig.ChainID,
+		GasLimit:        cfg.GasLimit,
+		GasPrice:        cfg.GasPrice,
+		Value:           cfg.Value,
+		Caller:          cfg.Origin,
+		CallerBalance:   cfg.State.GetBalance(cfg.Origin),
+		Address:         cfg.Env.Address,
+		Nonce:           cfg.Env.Nonce,
+		CreateAddress:   cfg.Env.CreateAddress(),
+		CreateCollision: cfg.Env.CreateCollision,
+		Coinbase:        cfg.Coinbase,
+		BlockNumber:     cfg.BlockNumber,
+		Time:            cfg.Time,
+		Difficulty:      cfg.Difficulty,
+		Storage:         cfg.Env.Storage,
+		WarmAccessList:  cfg.Env.WarmAccessList,
+		BlockHashes:     cfg.Env.BlockHashes,
+		HashSeed:        cfg.Env.HashSeed,
+		StateAccounts:   len(cfg.Env.StateSnapshot),
+
+		PreimageRecording: cfg.EVMConfig.EnablePreimageRecording,
+		ExtraEips:         cfg.EVMConfig.ExtraEips,
+	}
+	if cfg.ChainConfig.IsLondon(cfg.BlockNumber) {
+		config.BaseFee = cfg.BaseFee
+	}
+	return json.NewEncoder(out).Encode(config)
+}
+
+// based on setDefaults of github.com/ethereum/go-ethereum/core/vm/runtime/runtime.go,
+// so that we skip this in measured code. Unlike upstream, GetHashFn is left to NewConfig, which returns the BlockHashes
+// of the environment first, then the default hashes of blockHash. BaseFee defaults to params.InitialBaseFee,
+// which the copy predating London lacked
+func setDefaults(cfg *runtime.Config) {
+	if cfg.ChainConfig == nil {
+		cfg.ChainConfig = &params.ChainConfig{
+			ChainID:             big.NewInt(1),
+			HomesteadBlock:      new(big.Int),
+			DAOForkBlock:        new(big.Int),
+			DAOForkSupport:      false,
+			EIP150Block:         new(big.Int),
+			EIP150Hash:          common.Hash{},
+			EIP155Block:         new(big.Int),
+			EIP158Block:         new(big.Int),
+			ByzantiumBlock:      new(big.Int),
+			ConstantinopleBlock: new(big.Int),
+			PetersburgBlock:     new(big.Int),
+			IstanbulBlock:       new(big.Int),
+			MuirGlacierBlock:    new(big.Int),
+			BerlinBlock:         new(big.Int),
+			LondonBlock:         new(big.Int),
+		}
+	}
+
+	if cfg.Difficulty == nil {
+		cfg.Difficulty = new(big.Int)
+	}
+	if cfg.Time == nil {
+		cfg.Time = big.NewInt(time.Now().Unix())
+	}
+	if cfg.GasLimit == 0 {
+		cfg.GasLimit = math.MaxUint64
+	}
+	if cfg.GasPrice == nil {
+		cfg.GasPrice = new(big.Int)
+	}
+	if cfg.Value == nil {
+		cfg.Value = new(big.Int)
+	}
+	if cfg.BlockNumber == nil {
+		cfg.BlockNumber = new(big.Int)
+	}
+	if cfg.BaseFee == nil {
+		cfg.BaseFee = big.NewInt(params.InitialBaseFee)
+	}
+}
+
+// forks supported by the go-ethereum version in use, in activation order
+var forks = []struct {
+	name     string
+	activate func(chainConfig *params.ChainConfig)
+}{
+	{"homestead", func(chainConfig *params.ChainConfig) { chainConfig.HomesteadBlock = new(big.Int) }},
+	// EIP-150 reprices BALANCE, SLOAD, CALL and EXTCODE*
+	{"tangerine", func(chainConfig *params.ChainConfig) { chainConfig.EIP150Block = new(big.Int) }},
+	// EIP-155 and EIP-158, the latter clearing empty accounts
+	{"spuriousdragon", func(chainConfig *params.ChainConfig) {
+		chainConfig.EIP155Block = new(big.Int)
+		chainConfig.EIP158Block = new(big.Int)
+	}},
+	{"byzantium", func(chainConfig *params.ChainConfig) { chainConfig.ByzantiumBlock = new(big.Int) }},
+	{"petersburg", func(chainConfig *params.ChainConfig) {
+		chainConfig.ConstantinopleBlock = new(big.Int)
+		chainConfig.PetersburgBlock = new(big.Int)
+	}},
+	{"istanbul", func(chainConfig *params.ChainConfig) {
+		chainConfig.IstanbulBlock = new(big.Int)
+		chainConfig.MuirGlacierBlock = new(big.Int)
+	}},
+	{"berlin", func(chainConfig *params.ChainConfig) { chainConfig.BerlinBlock = new(big.Int) }},
+	{"london", func(chainConfig *params.ChainConfig) { chainConfig.LondonBlock = new(big.Int) }},
+}
+
+// laterForks are forks after the last one in forks, which the go-ethereum version in use predates, with the opcodes they introduce.
+// These opcodes are undefined in all the supported forks, so they fail with an invalid opcode error
+var laterForks = map[string]string{
+	"paris":    "PREVRANDAO in place of DIFFICULTY",
+	"shanghai": "PUSH0",
+	"cancun":   "TLOAD, TSTORE, MCOPY, BLOBHASH, BLOBBASEFEE and transient storage",
+}
+
+func ForkNames() []string {
+	names := make([]string, len(forks))
+	for i, fork := range forks {
+		names[i] = fork.name
+	}
+	return names
+}
+
+// ChainConfigForFork activates all forks up to and including the given one at block 0, leaving later forks unactivated
+func ChainConfigForFork(name string) (*params.ChainConfig, error) {
+	chainConfig := &params.ChainConfig{
+		ChainID:        big.NewInt(1),
+		DAOForkSupport: false,
+		EIP150Hash:     common.Hash{},
+	}
+	for _, fork := range forks {
+		fork.activate(chainConfig)
+		if fork.name == name {
+			return chainConfig, nil
+		}
+	}
+	if opcodes, ok := laterForks[name]; ok {
+		return nil, fmt.Errorf("Unsupported fork: %v (introducing %v), the go-ethereum version in use predates it. Available options: %v", name, opcodes, strings.Join(ForkNames(), ", "))
+	}
+	return nil, fmt.Errorf("Invalid fork: %v. Available options: %v", name, strings.Join(ForkNames(), ", "))
+}
+
+// for full options see github.com/ethereum/go-ethereum/core/vm/logger.go:50
+func setDefaultTracerConfig(cfg *vm.LogConfig) {
+	// Necessary, otherwise the initial memory allocation causes that memory to get copied over each instruction.
+	// If we need this to be enabled in the future, we need to rethink the initial MSTORE8
+	cfg.EnableMemory = false
+	cfg.DisableStack = false
+	cfg.DisableStorage = true
+	cfg.EnableReturnData = true
+	cfg.Debug = false
+	cfg.Limit = 0
+}
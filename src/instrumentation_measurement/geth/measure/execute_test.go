@@ -0,0 +1,189 @@
+package measure
+
+import (
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// newTestConfig is the config of the measurement of a fork, with the defaults of the flags and the diagnostics discarded
+func newTestConfig(t *testing.T, fork string) *Config {
+	t.Helper()
+	chainConfig, err := ChainConfigForFork(fork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := NewConfig(chainConfig, math.MaxUint64, new(big.Int), common.Address{}, Block{}, Environment{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Stderr, cfg.Info = io.Discard, io.Discard
+	return cfg
+}
+
+// executeOnce runs the code once on london and returns its return data, failing the test on an execution error
+func executeOnce(t *testing.T, code []byte) []byte {
+	t.Helper()
+	cfg := newTestConfig(t, "london")
+	prepareState(cfg, code)
+	ret, _, err := execute(nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+// TestColdAccessEveryRun checks that the first access of every run is charged cold, as the access list is prepared once
+// and reverted after every run, of a reused EVM as well, so neither -coldStorage nor -coldAccess is needed to measure the cold path
+func TestColdAccessEveryRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytecode []byte
+		gasUsed  uint64
+	}{
+		// PUSH1 1 SLOAD POP STOP
+		{"SLOAD", []byte{0x60, 0x01, 0x54, 0x50, 0x00}, 3 + 2100 + 2},
+		// PUSH1 0xaa BALANCE POP STOP
+		{"BALANCE", []byte{0x60, 0xaa, 0x31, 0x50, 0x00}, 3 + 2600 + 2},
+	}
+	storage := map[common.Hash]common.Hash{common.BigToHash(big.NewInt(1)): common.BigToHash(big.NewInt(0xff))}
+
+	for _, test := range tests {
+		for _, reuseEVM := range []bool{false, true} {
+			cfg := newTestConfig(t, "berlin")
+			cfg.Env.Storage = storage
+			prepareState(cfg, test.bytecode)
+			var reuse *reusableExecution
+			if reuseEVM {
+				reuse = newReusableExecution(cfg, nil)
+			}
+			for run := 0; run < 3; run++ {
+				var leftOverGas uint64
+				var err error
+				if reuseEVM {
+					_, leftOverGas, _, _, err = reuse.run()
+				} else {
+					_, leftOverGas, err = execute(nil, cfg)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if gasUsed := cfg.GasLimit - leftOverGas; gasUsed != test.gasUsed {
+					t.Errorf("%v, reuseEVM %v, run %d: gas used %d, expected %d", test.name, reuseEVM, run, gasUsed, test.gasUsed)
+				}
+			}
+		}
+	}
+}
+
+// TestRunsStartFromSameState checks that every execution starts from the state prepared once for the program, as the changes
+// of the previous ones are reverted, and that the program leaves the state of NewConfig behind
+func TestRunsStartFromSameState(t *testing.T) {
+	// CALL(GAS, 0xaa, 1, 0, 0, 0, 0) POP, then return BALANCE(0xaa)
+	bytecode := []byte{0x60, 0x00, 0x60, 0x00, 0x60, 0x00, 0x60, 0x00, 0x60, 0x01, 0x60, 0xaa, 0x5a, 0xf1, 0x50,
+		0x60, 0xaa, 0x31, 0x60, 0x00, 0x52, 0x60, 0x20, 0x60, 0x00, 0xf3}
+	cfg := newTestConfig(t, "london")
+	cfg.Value = big.NewInt(1)
+	cfg.Env.SenderBalance = big.NewInt(1)
+	prepareState(cfg, bytecode)
+	for run := 0; run < 3; run++ {
+		ret, _, err := execute(nil, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance := new(big.Int).SetBytes(ret); balance.Cmp(big.NewInt(1)) != 0 {
+			t.Errorf("run %d: balance of the recipient %v, expected 1", run, balance)
+		}
+	}
+
+	cfg = newTestConfig(t, "london")
+	if _, err := MeasureProgram(cfg, bytecode, nil, ProgramOptions{Mode: "total", Warmup: 1, SampleSize: 2, ContinueOnError: true}); err != nil {
+		t.Fatal(err)
+	}
+	if code := cfg.State.GetCode(cfg.Env.Address); len(code) != 0 {
+		t.Errorf("code left at the contract address after the program: %x", code)
+	}
+}
+
+// TestResetInstrumenter checks that a run with the instrumenter reset after a longer run records the same logs as one with
+// a new instrumenter, but for the times, in the buffer of the previous run
+func TestResetInstrumenter(t *testing.T) {
+	// PUSH1 1 PUSH1 2 ADD POP STOP, then PUSH1 1 POP STOP
+	long := []byte{0x60, 0x01, 0x60, 0x02, 0x01, 0x50, 0x00}
+	short := []byte{0x60, 0x01, 0x50, 0x00}
+	logs := func(cfg *Config, bytecode []byte) []vm.InstrumenterLog {
+		t.Helper()
+		snapshot := cfg.State.Snapshot()
+		defer cfg.State.RevertToSnapshot(snapshot)
+		prepareState(cfg, bytecode)
+		if _, _, err := execute(nil, cfg); err != nil {
+			t.Fatal(err)
+		}
+		logs := append([]vm.InstrumenterLog(nil), cfg.EVMConfig.Instrumenter.Logs...)
+		for i := range logs {
+			logs[i].TimeNs = 0
+		}
+		return logs
+	}
+
+	cfg := newTestConfig(t, "london")
+	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+	expected := logs(cfg, short)
+
+	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+	logs(cfg, long)
+	buffer := cfg.EVMConfig.Instrumenter.Logs
+	resetInstrumenter(cfg)
+	if instrumenter := cfg.EVMConfig.Instrumenter; len(instrumenter.Logs) != 0 || instrumenter.TotalExecutionDuration != 0 {
+		t.Fatalf("instrumenter not cleared by the reset: %d logs, total %v", len(instrumenter.Logs), instrumenter.TotalExecutionDuration)
+	}
+	if reset := logs(cfg, short); !reflect.DeepEqual(reset, expected) {
+		t.Errorf("logs after the reset:\n%v\nexpected:\n%v", reset, expected)
+	}
+	if &cfg.EVMConfig.Instrumenter.Logs[0] != &buffer[0] {
+		t.Error("the logs after the reset are not recorded in the buffer of the previous run")
+	}
+}
+
+func TestChainConfigForFork(t *testing.T) {
+	// the rules every fork enables, in fork order, so that each fork enables its own and those of all the earlier ones only
+	enabled := []struct {
+		fork  string
+		rules func(rules params.Rules) []bool
+	}{
+		{"homestead", func(rules params.Rules) []bool { return []bool{rules.IsHomestead} }},
+		{"tangerine", func(rules params.Rules) []bool { return []bool{rules.IsEIP150} }},
+		{"spuriousdragon", func(rules params.Rules) []bool { return []bool{rules.IsEIP155, rules.IsEIP158} }},
+		{"byzantium", func(rules params.Rules) []bool { return []bool{rules.IsByzantium} }},
+		{"petersburg", func(rules params.Rules) []bool { return []bool{rules.IsConstantinople, rules.IsPetersburg} }},
+		{"istanbul", func(rules params.Rules) []bool { return []bool{rules.IsIstanbul} }},
+		{"berlin", func(rules params.Rules) []bool { return []bool{rules.IsBerlin} }},
+		{"london", func(rules params.Rules) []bool { return []bool{rules.IsLondon} }},
+	}
+	if len(enabled) != len(forks) {
+		t.Fatalf("%d forks tested, %d supported", len(enabled), len(forks))
+	}
+	for i, fork := range enabled {
+		chainConfig, err := ChainConfigForFork(fork.fork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules := chainConfig.Rules(new(big.Int), false)
+		for j, other := range enabled {
+			for _, set := range other.rules(rules) {
+				if expected := j <= i; set != expected {
+					t.Errorf("%v: a rule of %v is %v, expected %v", fork.fork, other.fork, set, expected)
+				}
+			}
+		}
+	}
+	if _, err := ChainConfigForFork("frontier"); err == nil {
+		t.Error("expected an error for an unsupported fork")
+	}
+}
@@ -0,0 +1,108 @@
+package measure
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// flamegraphRoot is the frame all the folded stacks of mode flamegraph start with, the executed bytecode
+const flamegraphRoot = "bytecode"
+
+// flamegraph sums the instrumenter measurements of all the runs of a sample per folded stack of the executed opcodes,
+// see foldedStacks. The logs carry no call depth, so they are matched with the steps of a recorded run by their instruction index
+type flamegraph struct {
+	stacks []string
+	// pcs of the steps, to tell a run which took a different path
+	pcs []uint64
+	// measured tells if every step is one of the measured instructions, see ProgramOptions.MeasuredRange
+	measured []bool
+	timeNs   map[string]int64
+	stderr   io.Writer
+}
+
+// newFlamegraph runs the bytecode once with the tracer, untimed, to record the folded stacks the runs are matched with
+func newFlamegraph(cfg *Config, calldata []byte, opts ProgramOptions) *flamegraph {
+	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+	timer := new(opcodeTimer)
+	cfg.EVMConfig.Tracer = timer
+	cfg.EVMConfig.Debug = true
+	defer func() {
+		cfg.EVMConfig.Tracer = nil
+		cfg.EVMConfig.Debug = false
+	}()
+
+	execute(calldata, cfg)
+	graph := &flamegraph{stacks: foldedStacks(timer.timings), timeNs: make(map[string]int64), stderr: cfg.Stderr}
+	for _, timing := range timer.timings {
+		graph.pcs = append(graph.pcs, timing.pc)
+		graph.measured = append(graph.measured, opts.measuredPc(timing.pc))
+	}
+	return graph
+}
+
+// foldedStacks returns the folded stack of every step: the root frame, the opcodes of the calls and creations the step is nested in,
+// outermost first, and its own opcode, separated by semicolons. A step a frame deeper than the previous one is the first of a call
+// by the previous step
+func foldedStacks(timings []opcodeTiming) []string {
+	stacks := make([]string, len(timings))
+	frames := []string{flamegraphRoot}
+	for i, timing := range timings {
+		for len(frames) > timing.depth && len(frames) > 1 {
+			frames = frames[:len(frames)-1]
+		}
+		if i > 0 && len(frames) < timing.depth {
+			frames = append(frames, timings[i-1].op.String())
+		}
+		stacks[i] = strings.Join(frames, ";") + ";" + timing.op.String()
+	}
+	return stacks
+}
+
+// add sums the logs of a run into the stacks of the measured steps. A run of other steps than the recorded one
+// (it took a different path) is left out, with a warning
+func (g *flamegraph) add(logs []vm.InstrumenterLog, sampleId int) {
+	if len(logs) != len(g.stacks) {
+		fmt.Fprintf(g.stderr, "Run %d executed %d opcodes, %d recorded, leaving it out of the flame graph\n", sampleId, len(logs), len(g.stacks))
+		return
+	}
+	for i, log := range logs {
+		if log.Pc != g.pcs[i] {
+			fmt.Fprintf(g.stderr, "Run %d executed pc %d at step %d, pc %d recorded, leaving it out of the flame graph\n", sampleId, log.Pc, i, g.pcs[i])
+			return
+		}
+	}
+	for i, log := range logs {
+		if g.measured[i] {
+			g.timeNs[g.stacks[i]] += log.TimeNs
+		}
+	}
+}
+
+// write writes a line per folded stack, the stack and the summed time in nanoseconds separated by a space, sorted by the stack,
+// the collapsed format of flamegraph.pl, inferno and speedscope
+func (g *flamegraph) write(out io.Writer) {
+	stacks := make([]string, 0, len(g.timeNs))
+	for stack := range g.timeNs {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		fmt.Fprintf(out, "%v %d\n", stack, g.timeNs[stack])
+	}
+}
+
+// measureFlamegraph runs the bytecode once, instrumented, and sums its logs into the graph
+func measureFlamegraph(cfg *Config, bytecode []byte, calldata []byte, graph *flamegraph, results io.Writer, sampleId int) {
+	resetInstrumenter(cfg)
+
+	startUnixNs := time.Now().UnixNano()
+	ret, leftOverGas, err := execute(calldata, cfg)
+	printExecutionError(cfg.Stderr, ret, err)
+	writeResultCSV(results, sampleId, startUnixNs, ret, err, len(cfg.EVMConfig.Instrumenter.Logs), cfg.GasLimit, leftOverGas)
+	graph.add(cfg.EVMConfig.Instrumenter.Logs, sampleId)
+}
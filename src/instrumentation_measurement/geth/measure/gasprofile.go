@@ -0,0 +1,196 @@
+package measure
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// frontierStaticGas is the constant gas of the Frontier jump table, opcodes left out have none.
+// Copied from github.com/ethereum/go-ethereum/core/vm/jump_table.go, which does not export it
+var frontierStaticGas = func() map[vm.OpCode]uint64 {
+	gas := map[vm.OpCode]uint64{
+		vm.ADD:        vm.GasFastestStep,
+		vm.MUL:        vm.GasFastStep,
+		vm.SUB:        vm.GasFastestStep,
+		vm.DIV:        vm.GasFastStep,
+		vm.SDIV:       vm.GasFastStep,
+		vm.MOD:        vm.GasFastStep,
+		vm.SMOD:       vm.GasFastStep,
+		vm.ADDMOD:     vm.GasMidStep,
+		vm.MULMOD:     vm.GasMidStep,
+		vm.SIGNEXTEND: vm.GasFastStep,
+
+		vm.LT:     vm.GasFastestStep,
+		vm.GT:     vm.GasFastestStep,
+		vm.SLT:    vm.GasFastestStep,
+		vm.SGT:    vm.GasFastestStep,
+		vm.EQ:     vm.GasFastestStep,
+		vm.ISZERO: vm.GasFastestStep,
+		vm.AND:    vm.GasFastestStep,
+		vm.OR:     vm.GasFastestStep,
+		vm.XOR:    vm.GasFastestStep,
+		vm.NOT:    vm.GasFastestStep,
+		vm.BYTE:   vm.GasFastestStep,
+
+		vm.KECCAK256: params.Keccak256Gas,
+
+		vm.ADDRESS:      vm.GasQuickStep,
+		vm.BALANCE:      params.BalanceGasFrontier,
+		vm.ORIGIN:       vm.GasQuickStep,
+		vm.CALLER:       vm.GasQuickStep,
+		vm.CALLVALUE:    vm.GasQuickStep,
+		vm.CALLDATALOAD: vm.GasFastestStep,
+		vm.CALLDATASIZE: vm.GasQuickStep,
+		vm.CALLDATACOPY: vm.GasFastestStep,
+		vm.CODESIZE:     vm.GasQuickStep,
+		vm.CODECOPY:     vm.GasFastestStep,
+		vm.GASPRICE:     vm.GasQuickStep,
+		vm.EXTCODESIZE:  params.ExtcodeSizeGasFrontier,
+		vm.EXTCODECOPY:  params.ExtcodeCopyBaseFrontier,
+
+		vm.BLOCKHASH:  vm.GasExtStep,
+		vm.COINBASE:   vm.GasQuickStep,
+		vm.TIMESTAMP:  vm.GasQuickStep,
+		vm.NUMBER:     vm.GasQuickStep,
+		vm.DIFFICULTY: vm.GasQuickStep,
+		vm.GASLIMIT:   vm.GasQuickStep,
+
+		vm.POP:      vm.GasQuickStep,
+		vm.MLOAD:    vm.GasFastestStep,
+		vm.MSTORE:   vm.GasFastestStep,
+		vm.MSTORE8:  vm.GasFastestStep,
+		vm.SLOAD:    params.SloadGasFrontier,
+		vm.JUMP:     vm.GasMidStep,
+		vm.JUMPI:    vm.GasSlowStep,
+		vm.PC:       vm.GasQuickStep,
+		vm.MSIZE:    vm.GasQuickStep,
+		vm.GAS:      vm.GasQuickStep,
+		vm.JUMPDEST: params.JumpdestGas,
+
+		vm.CREATE:   params.CreateGas,
+		vm.CALL:     params.CallGasFrontier,
+		vm.CALLCODE: params.CallGasFrontier,
+	}
+	for op := vm.OpCode(vm.PUSH1); op <= vm.PUSH32; op++ {
+		gas[op] = vm.GasFastestStep
+	}
+	for op := vm.OpCode(vm.DUP1); op <= vm.DUP16; op++ {
+		gas[op] = vm.GasFastestStep
+	}
+	for op := vm.OpCode(vm.SWAP1); op <= vm.SWAP16; op++ {
+		gas[op] = vm.GasFastestStep
+	}
+	return gas
+}()
+
+// staticGasChanges are the changes of the constant gas made by the later jump tables, in fork order
+var staticGasChanges = []struct {
+	enabled func(rules params.Rules) bool
+	gas     map[vm.OpCode]uint64
+}{
+	{func(rules params.Rules) bool { return rules.IsHomestead }, map[vm.OpCode]uint64{
+		vm.DELEGATECALL: params.CallGasFrontier,
+	}},
+	{func(rules params.Rules) bool { return rules.IsEIP150 }, map[vm.OpCode]uint64{
+		vm.BALANCE: params.BalanceGasEIP150, vm.EXTCODESIZE: params.ExtcodeSizeGasEIP150, vm.EXTCODECOPY: params.ExtcodeCopyBaseEIP150,
+		vm.SLOAD: params.SloadGasEIP150, vm.CALL: params.CallGasEIP150, vm.CALLCODE: params.CallGasEIP150, vm.DELEGATECALL: params.CallGasEIP150,
+	}},
+	{func(rules params.Rules) bool { return rules.IsByzantium }, map[vm.OpCode]uint64{
+		vm.RETURNDATASIZE: vm.GasQuickStep, vm.RETURNDATACOPY: vm.GasFastestStep, vm.STATICCALL: params.CallGasEIP150,
+	}},
+	{func(rules params.Rules) bool { return rules.IsConstantinople }, map[vm.OpCode]uint64{
+		vm.SHL: vm.GasFastestStep, vm.SHR: vm.GasFastestStep, vm.SAR: vm.GasFastestStep,
+		vm.EXTCODEHASH: params.ExtcodeHashGasConstantinople, vm.CREATE2: params.Create2Gas,
+	}},
+	{func(rules params.Rules) bool { return rules.IsIstanbul }, map[vm.OpCode]uint64{
+		vm.BALANCE: params.BalanceGasEIP1884, vm.EXTCODEHASH: params.ExtcodeHashGasEIP1884, vm.CHAINID: vm.GasQuickStep,
+		vm.SELFBALANCE: vm.GasFastStep, vm.SLOAD: params.SloadGasEIP2200,
+	}},
+	// EIP-2929 moves the cold surcharge into the dynamic gas
+	{func(rules params.Rules) bool { return rules.IsBerlin }, map[vm.OpCode]uint64{
+		vm.BALANCE: params.WarmStorageReadCostEIP2929, vm.EXTCODESIZE: params.WarmStorageReadCostEIP2929,
+		vm.EXTCODECOPY: params.WarmStorageReadCostEIP2929, vm.EXTCODEHASH: params.WarmStorageReadCostEIP2929, vm.SLOAD: 0,
+		vm.CALL: params.WarmStorageReadCostEIP2929, vm.CALLCODE: params.WarmStorageReadCostEIP2929,
+		vm.DELEGATECALL: params.WarmStorageReadCostEIP2929, vm.STATICCALL: params.WarmStorageReadCostEIP2929,
+		vm.SELFDESTRUCT: params.SelfdestructGasEIP150,
+	}},
+	{func(rules params.Rules) bool { return rules.IsLondon }, map[vm.OpCode]uint64{
+		vm.BASEFEE: vm.GasQuickStep,
+	}},
+}
+
+// staticGas returns the constant gas of the opcode in the jump table of the rules, charged along with its dynamic gas
+func staticGas(op vm.OpCode, rules params.Rules) uint64 {
+	gas := frontierStaticGas[op]
+	for _, change := range staticGasChanges {
+		if !change.enabled(rules) {
+			break
+		}
+		if changed, ok := change.gas[op]; ok {
+			gas = changed
+		}
+	}
+	return gas
+}
+
+// gasProfile is the number of executions, the summed static and dynamic gas and time of an opcode within a run
+type gasProfile struct {
+	count      int
+	staticGas  uint64
+	dynamicGas uint64
+	timeNs     int64
+}
+
+// MeasureGasProfile times every executed opcode like MeasureOpcodes and joins the timings with the gas charged for them,
+// split into the static gas of the jump table and the dynamic rest, see writeCSVGasProfile
+func MeasureGasProfile(cfg *Config, bytecode []byte, calldata []byte, printCSV bool, out io.Writer, results io.Writer, sampleId int) {
+	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+	timer := new(opcodeTimer)
+	cfg.EVMConfig.Tracer = timer
+	cfg.EVMConfig.Debug = true
+
+	startUnixNs := time.Now().UnixNano()
+	ret, leftOverGas, err := execute(calldata, cfg)
+	printExecutionError(cfg.Stderr, ret, err)
+	writeResultCSV(results, sampleId, startUnixNs, ret, err, len(timer.timings), cfg.GasLimit, leftOverGas)
+
+	if printCSV {
+		writeCSVGasProfile(out, timer.timings, cfg.ChainConfig.Rules(cfg.BlockNumber, false), sampleId)
+	}
+}
+
+// writeCSVGasProfile writes a row per distinct executed opcode: sampleId, op, count, summed static gas, dynamic gas and time
+// in nanoseconds, sorted by the opcode byte. The gas of the CALL and CREATE families includes the gas passed on to the callee
+func writeCSVGasProfile(out io.Writer, timings []opcodeTiming, rules params.Rules, sampleId int) {
+	profiles := make(map[vm.OpCode]*gasProfile)
+	for _, timing := range timings {
+		profile, ok := profiles[timing.op]
+		if !ok {
+			profile = new(gasProfile)
+			profiles[timing.op] = profile
+		}
+		static := staticGas(timing.op, rules)
+		profile.count++
+		profile.staticGas += static
+		// an opcode failing for lack of gas is reported with the cost it could not pay, which may leave out the static gas
+		if timing.gasCost > static {
+			profile.dynamicGas += timing.gasCost - static
+		}
+		profile.timeNs += timing.timeNs
+	}
+
+	ops := make([]vm.OpCode, 0, len(profiles))
+	for op := range profiles {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i] < ops[j] })
+	for _, op := range ops {
+		profile := profiles[op]
+		fmt.Fprintf(out, "%d,%v,%d,%d,%d,%d\n", sampleId, CSVField(op.String()), profile.count, profile.staticGas, profile.dynamicGas, profile.timeNs)
+	}
+}
@@ -0,0 +1,85 @@
+package measure
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// haltTracer is a vm.EVMLogger keeping the last opcode of the outermost frame, see haltReason, and the contracts created
+// by CREATE and CREATE2, see writeCreations
+type haltTracer struct {
+	lastPc    uint64
+	lastOp    vm.OpCode
+	creations []creation
+	// frames holds the index into creations of every entered frame, -1 for calls
+	frames []int
+}
+
+// creation is a contract created during the execution
+type creation struct {
+	op      vm.OpCode
+	address common.Address
+	err     error
+}
+
+func (g *haltTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if depth == 1 {
+		g.lastPc, g.lastOp = pc, op
+	}
+}
+
+func (g *haltTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+func (g *haltTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {}
+
+func (g *haltTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	if typ == vm.CREATE || typ == vm.CREATE2 {
+		g.frames = append(g.frames, len(g.creations))
+		g.creations = append(g.creations, creation{op: typ, address: to})
+	} else {
+		g.frames = append(g.frames, -1)
+	}
+}
+
+func (g *haltTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if len(g.frames) == 0 {
+		return
+	}
+	if i := g.frames[len(g.frames)-1]; i >= 0 {
+		g.creations[i].err = err
+	}
+	g.frames = g.frames[:len(g.frames)-1]
+}
+
+// writeCreations writes the address of every created contract, or why the creation failed
+func (g *haltTracer) writeCreations(out io.Writer) {
+	for _, c := range g.creations {
+		if c.err != nil {
+			fmt.Fprintf(out, "%v of %v failed: %v\n", c.op, c.address.Hex(), c.err)
+		} else {
+			fmt.Fprintf(out, "%v created contract: %v\n", c.op, c.address.Hex())
+		}
+	}
+}
+
+// haltReason tells how the execution of the bytecode traced by g halted: the halting opcode,
+// end of code, if it ran past the last instruction (which executes as an implicit STOP), or the error
+func (g *haltTracer) haltReason(bytecode []byte, err error) string {
+	if err != nil && !errors.Is(err, vm.ErrExecutionReverted) {
+		return "error (" + err.Error() + ")"
+	}
+	if g.lastOp == vm.STOP && g.lastPc >= uint64(len(bytecode)) {
+		return "end of code (implicit STOP)"
+	}
+	return g.lastOp.String()
+}
+
+func (g *haltTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
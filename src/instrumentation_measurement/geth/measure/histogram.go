@@ -0,0 +1,72 @@
+package measure
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// opcodeCounter is a vm.EVMLogger counting the executed opcodes, in all frames
+type opcodeCounter struct {
+	counts map[vm.OpCode]int
+	total  int
+}
+
+func (c *opcodeCounter) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	c.counts[op]++
+	c.total++
+}
+
+func (c *opcodeCounter) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+func (c *opcodeCounter) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {}
+
+func (c *opcodeCounter) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+func (c *opcodeCounter) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+func (c *opcodeCounter) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+// writeCSVHistogram writes a row per executed opcode: sampleId, op, count and percentage share of all executed opcodes,
+// sorted by count, most frequent first, followed by a row with the total
+func (c *opcodeCounter) writeCSVHistogram(out io.Writer, sampleId int) {
+	ops := make([]vm.OpCode, 0, len(c.counts))
+	for op := range c.counts {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if c.counts[ops[i]] != c.counts[ops[j]] {
+			return c.counts[ops[i]] > c.counts[ops[j]]
+		}
+		return ops[i] < ops[j]
+	})
+	for _, op := range ops {
+		fmt.Fprintf(out, "%d,%v,%d,%.2f\n", sampleId, CSVField(op.String()), c.counts[op], 100*float64(c.counts[op])/float64(c.total))
+	}
+	fmt.Fprintf(out, "%d,total,%d,100.00\n", sampleId, c.total)
+}
+
+// MeasureHistogram counts how many times every opcode is executed by the run, see opcodeCounter
+func MeasureHistogram(cfg *Config, bytecode []byte, calldata []byte, printCSV bool, out io.Writer, results io.Writer, sampleId int) {
+	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+	counter := &opcodeCounter{counts: make(map[vm.OpCode]int)}
+	cfg.EVMConfig.Tracer = counter
+	cfg.EVMConfig.Debug = true
+
+	startUnixNs := time.Now().UnixNano()
+	ret, leftOverGas, err := execute(calldata, cfg)
+	printExecutionError(cfg.Stderr, ret, err)
+	writeResultCSV(results, sampleId, startUnixNs, ret, err, counter.total, cfg.GasLimit, leftOverGas)
+
+	if printCSV {
+		counter.writeCSVHistogram(out, sampleId)
+	}
+}
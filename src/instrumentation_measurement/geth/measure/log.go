@@ -0,0 +1,24 @@
+package measure
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// LogDataPrelude returns the code, starting at pc start, populating the first size bytes of memory and leaving the size and
+// the offset (0) of that buffer on top of the stack, the operands of a LOG0-LOG4 put after it, to be put in front of the measured
+// bytecode. The topics of LOG1-LOG4 go below, e.g. from a StackPrelude in front of it. The data is all INVALID (0xfe) bytes,
+// see memoryPrelude, so that memory is populated, i.e. expanded and non-zero, before the LOG runs and the LOG only pays for logging
+func LogDataPrelude(start int, size int) ([]byte, error) {
+	if size < 0 || size > maxPreludeDataSize {
+		return nil, fmt.Errorf("log data size must be between 0 and %d bytes, got %d", maxPreludeDataSize, size)
+	}
+	prelude, err := memoryPrelude(start, bytes.Repeat([]byte{byte(vm.INVALID)}, size))
+	if err != nil {
+		return nil, err
+	}
+	prelude = appendPush4(prelude, size)
+	return append(prelude, byte(vm.PUSH1), 0), nil
+}
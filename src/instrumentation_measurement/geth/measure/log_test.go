@@ -0,0 +1,35 @@
+package measure
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLogDataPrelude(t *testing.T) {
+	tests := []struct {
+		start int
+		size  int
+	}{
+		{0, 0},
+		{0, 1},
+		{0, 100},
+		{5, 32},
+	}
+	for _, test := range tests {
+		prelude, err := LogDataPrelude(test.start, test.size)
+		if err != nil {
+			t.Fatalf("start %d, size %d: %v", test.start, test.size, err)
+		}
+		// the offset and the size left on the stack are the operands of RETURN as well
+		code := append(append(bytes.Repeat([]byte{0x5b}, test.start), prelude...), 0xf3)
+		if ret, expected := executeOnce(t, code), bytes.Repeat([]byte{0xfe}, test.size); !bytes.Equal(ret, expected) {
+			t.Errorf("start %d, size %d: data %x, expected %x", test.start, test.size, ret, expected)
+		}
+	}
+
+	for _, size := range []int{-1, maxPreludeDataSize + 1} {
+		if _, err := LogDataPrelude(0, size); err == nil {
+			t.Errorf("size %d: expected an error", size)
+		}
+	}
+}
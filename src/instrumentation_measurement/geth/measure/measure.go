@@ -0,0 +1,836 @@
+// Package measure runs and times EVM bytecode with the instrumented go-ethereum interpreter.
+// Measure is the programmatic entry point, the exported Measure* functions are the building blocks of the command line tool.
+package measure
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	go_runtime "runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Modes are the available measurement modes, see MeasureProgram
+var Modes = []string{"all", "total", "trace", "traceJSON", "opcode", "alloc", "cycles", "histogram", "gasprofile", "flamegraph", "disasm", "verify", "stacksweep", "noise", "replay"}
+
+// Options configure Measure, zero values select the defaults of the command line tool, unless noted otherwise
+type Options struct {
+	// Mode is one of Modes, all by default
+	Mode string
+	// SampleSize is the number of measured runs, 1 by default
+	SampleSize int
+	// Warmup is the number of discarded warm-up runs before the sample, none if 0
+	Warmup int
+	// Timeout, if positive, cancels every run running longer, see ProgramOptions.Timeout
+	Timeout time.Duration
+	// Strict fails before executing anything on a PUSH running past the end of the bytecode, see ValidatePushImmediates
+	Strict bool
+	// Calldata passed as input, DefaultCalldata if nil
+	Calldata []byte
+	// Fork which rules are used for execution, london by default, see ForkNames
+	Fork string
+	// GasLimit for the execution, math.MaxUint64 by default
+	GasLimit uint64
+	// Value sent along with the execution, 0 if nil
+	Value *big.Int
+	// Origin is the caller of the execution
+	Origin common.Address
+	// Block the executions run in
+	Block Block
+	// ReuseEVM calls the contract on a single EVM built for all the runs, see reusableExecution (modes all and total)
+	ReuseEVM bool
+	// GCMode is one of default, each, off, see MeasureProgram
+	GCMode string
+	// ContinueOnError measures the sample even if the warm-up run fails, otherwise Measure returns the error of the warm-up
+	ContinueOnError bool
+	// Env is the state the executions start from
+	Env Environment
+	// Out, if not nil, receives the CSV results of the mode
+	Out io.Writer
+	// Stderr and Info receive the diagnostics and the informational diagnostics, see Config, os.Stderr if nil
+	Stderr io.Writer
+	Info   io.Writer
+}
+
+// Result of Measure
+type Result struct {
+	// Stats of the durations of the measured runs (modes all and total)
+	Stats *DurationStats
+}
+
+// Measure runs the warm-up and the sample for the bytecode in a fresh state, see MeasureProgram
+func Measure(bytecode []byte, opts Options) (Result, error) {
+	mode := opts.Mode
+	if mode == "" {
+		mode = "all"
+	}
+	if !IsValidMode(mode) || mode == "disasm" || mode == "verify" || mode == "stacksweep" || mode == "noise" || mode == "replay" {
+		return Result{}, fmt.Errorf("Invalid measurement mode: %v", mode)
+	}
+	if opts.ReuseEVM && mode != "all" && mode != "total" {
+		return Result{}, errors.New("ReuseEVM is only available in modes all and total")
+	}
+	gcMode := opts.GCMode
+	if gcMode == "" {
+		gcMode = "default"
+	}
+	if gcMode != "default" && gcMode != "each" && gcMode != "off" {
+		return Result{}, fmt.Errorf("Invalid GC mode: %v", gcMode)
+	}
+	if opts.Strict {
+		if err := ValidatePushImmediates(bytecode); err != nil {
+			return Result{}, err
+		}
+	}
+	sampleSize := opts.SampleSize
+	if sampleSize == 0 {
+		sampleSize = 1
+	}
+	fork := opts.Fork
+	if fork == "" {
+		fork = "london"
+	}
+	chainConfig, err := ChainConfigForFork(fork)
+	if err != nil {
+		return Result{}, err
+	}
+	gasLimit := opts.GasLimit
+	if gasLimit == 0 {
+		gasLimit = math.MaxUint64
+	}
+	value := opts.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+	calldata := opts.Calldata
+	if calldata == nil {
+		calldata = DefaultCalldata()
+	}
+
+	cfg, _, err := NewConfig(chainConfig, gasLimit, value, opts.Origin, opts.Block, opts.Env, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	if opts.Stderr != nil {
+		cfg.Stderr = opts.Stderr
+	}
+	if opts.Info != nil {
+		cfg.Info = opts.Info
+	}
+	stats, err := MeasureProgram(cfg, bytecode, calldata, ProgramOptions{
+		Mode:            mode,
+		ReuseEVM:        opts.ReuseEVM,
+		Warmup:          opts.Warmup,
+		Timeout:         opts.Timeout,
+		ContinueOnError: opts.ContinueOnError,
+		SampleSize:      sampleSize,
+		GCMode:          gcMode,
+		PrintCSV:        opts.Out != nil,
+		Trace:           TraceColumns{StackColumns: DefaultTraceStackColumns},
+		Out:             opts.Out,
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Stats: stats}, nil
+}
+
+// DefaultCalldata is some constant calldata of 32KB, 2^15 bytes.
+// This means, if we offset between 0th and 2^14th byte, we can fetch between 0 and 2^14 bytes (16KB)
+// In consequence, we need args to memory-copying OPCODEs to be between 0 and 2^14, 2^14 fits in a PUSH2,
+// which we'll be using to generate arguments for those OPCODEs.
+func DefaultCalldata() []byte {
+	return []byte(strings.Repeat("{", 1<<15))
+}
+
+// CalldataOfSize returns the calldata repeated, and truncated, to the given size, zero bytes if the calldata is empty
+func CalldataOfSize(calldata []byte, size int) []byte {
+	sized := make([]byte, size)
+	if len(calldata) == 0 {
+		return sized
+	}
+	for i := range sized {
+		sized[i] = calldata[i%len(calldata)]
+	}
+	return sized
+}
+
+// CodeHash identifies the code, the first 8 bytes of its keccak256, in hex
+func CodeHash(bytecode []byte) string {
+	return hex.EncodeToString(crypto.Keccak256(bytecode)[:8])
+}
+
+// IsValidMode tells if the mode is one of Modes
+func IsValidMode(mode string) bool {
+	for _, m := range Modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// CSVHeader describes the columns of the CSV printed in the given mode, aggregate selects the per-opcode rows of mode all
+func CSVHeader(mode string, trace TraceColumns, aggregate bool, tagColumn string, epochs bool) string {
+	var columns []string
+	if tagColumn != "" {
+		columns = append(columns, tagColumn)
+	}
+	if epochs {
+		columns = append(columns, "epoch")
+	}
+	switch mode {
+	case "all", "replay":
+		if aggregate {
+			columns = append(columns, "run_id", "op", "count", "measure_all_time_ns", "mean_measure_all_time_ns")
+			break
+		}
+		columns = append(columns, "run_id", "instruction_id", "measure_all_time_ns", "measure_all_timer_time_ns")
+	case "total":
+		columns = append(columns, "run_id", "measure_total_time_ns", "measure_total_timer_time_ns")
+	case "trace":
+		columns = append(columns, "instruction_id", "pc", "op", "gas", "gas_cost", "stack_depth")
+		for i := 0; i < trace.StackColumns; i++ {
+			columns = append(columns, fmt.Sprintf("stack_%d", i))
+		}
+		if trace.Memory {
+			columns = append(columns, "memory_words")
+		}
+		if trace.MemoryLimit > 0 {
+			columns = append(columns, "memory")
+		}
+		if trace.OpNumeric {
+			columns = append(columns, "op_byte")
+		}
+		if trace.Storage {
+			columns = append(columns, "storage")
+		}
+		if trace.Branch {
+			columns = append(columns, "jump_taken", "jump_destination")
+		}
+	case "opcode", "stacksweep":
+		columns = append(columns, "run_id", "instruction_id", "pc", "op", "time_ns")
+		if trace.Branch {
+			columns = append(columns, "jump_taken", "jump_destination")
+		}
+	case "alloc":
+		columns = append(columns, "run_id", "mallocs", "allocated_bytes")
+	case "cycles":
+		columns = append(columns, "run_id", "cycles")
+	case "histogram":
+		columns = append(columns, "run_id", "op", "count", "percent")
+	case "gasprofile":
+		columns = append(columns, "run_id", "op", "count", "static_gas", "dynamic_gas", "time_ns")
+	case "disasm":
+		columns = append(columns, "pc", "op", "immediate")
+	case "verify":
+		columns = append(columns, "gas_used", "expected_gas", "status")
+	case "noise":
+		columns = append(columns, "runs", "min_ns", "median_ns", "p99_ns", "max_ns", "mean_ns", "stddev_ns")
+	}
+	return strings.Join(columns, ",")
+}
+
+// Calibrate measures the empty program (a single STOP) like MeasureProgram would measure a program in the given mode (all or total),
+// with the same sample size and the environment of cfg, without printing its results. The mean duration estimates the fixed cost of
+// entering the execution, see ProgramOptions.HarnessOverhead
+func Calibrate(cfg *Config, calldata []byte, mode string, reuseEVM bool, warmup int, sampleSize int, gcMode string) (*DurationStats, error) {
+	stop := []byte{byte(vm.STOP)}
+	return MeasureProgram(cfg, stop, calldata, ProgramOptions{Mode: mode, ReuseEVM: reuseEVM, Warmup: warmup, SampleSize: sampleSize, GCMode: gcMode})
+}
+
+// ProgramOptions configure MeasureProgram, zero values leave the options out, but Epochs, which stands for 1 then
+type ProgramOptions struct {
+	// Mode is one of Modes
+	Mode string
+	// ReuseEVM calls the contract on a single EVM built for all the runs, see reusableExecution (modes all and total)
+	ReuseEVM bool
+	// Warmup is the number of discarded warm-up runs before the sample
+	Warmup int
+	// Timeout, if positive, cancels every run, warm-up and measured, once it runs longer, see startTimeout. The sample is skipped if
+	// a warm-up run times out. A measured run timing out has the status timeout, see ErrorStatus, and is left out of the run durations
+	Timeout time.Duration
+	// ReportWarmUp traces the first warm-up run, to report how it halted and the contracts it created, see haltTracer
+	ReportWarmUp bool
+	// ContinueOnError measures the sample even if the last warm-up run fails, otherwise its error is returned
+	ContinueOnError bool
+	// SampleSize is the number of measured runs, see TargetSEM
+	SampleSize int
+	// TargetSEM, if positive, goes on past SampleSize until the standard error of the mean drops below it, see sampleDone
+	TargetSEM time.Duration
+	// MaxSamples bounds the sample of TargetSEM
+	MaxSamples int
+	// Epochs, if more than 1, repeats the sample that many times, pausing for EpochPause in between. CSV rows and JSON lines
+	// are tagged with the epoch and the epoch means are reported along with their spread, see writeEpochSummary
+	Epochs     int
+	EpochPause time.Duration
+	// GCMode is one of default, each, off
+	GCMode string
+	// PrintEach prints the duration and the gas, and in mode all the instrumentation, of every run to Info.
+	// PrintCSV prints the CSV rows of the mode to Out
+	PrintEach bool
+	PrintCSV  bool
+	// Aggregate prints per-opcode aggregates of every run in place of the instrumenter logs in mode all, see writeCSVAggregate
+	Aggregate bool
+	// Summary prints the percentiles of the run durations to the Stderr of the config
+	Summary bool
+	// HarnessOverhead is the mean duration of an empty program, see Calibrate. If positive, it is subtracted from the reported run
+	// durations (per-run lines, JSON lines and the summary), which are still reported raw alongside
+	HarnessOverhead time.Duration
+	// RunObserver, if not nil, is called with the duration of every measured run of modes all and total, e.g. to export metrics.
+	// Programs may be measured by several workers at once, so it must be safe for concurrent use
+	RunObserver func(duration time.Duration)
+	// MeasuredRange, if not nil, limits the per-opcode rows of modes all and opcode (and the aggregates and JSON measurements of mode all,
+	// and the flame graph) to the instructions within the range, so that the setup code around a measured region is left out.
+	// The whole run is still executed and timed, run durations are not affected. Instructions are numbered within the range
+	MeasuredRange *PcRange
+	// MeasuredPcs, if not nil, limits the per-opcode rows further, to the instructions at these pcs, e.g. those of a prior trace
+	// (mode replay), see ReadTracePcs. Like MeasuredRange, it does not affect run durations, and instructions are numbered within
+	// the measured ones
+	MeasuredPcs map[uint64]vm.OpCode
+	// Trace configures the columns of modes trace and traceJSON
+	Trace TraceColumns
+	// Out receives the CSV rows of the mode, discarded if nil
+	Out io.Writer
+	// Results, if not nil, receives a row for every measured run, see writeResultCSV and recordMemory, same for JSON and JSON lines
+	Results io.Writer
+	JSON    *JSONWriter
+}
+
+// MeasureProgram runs the warm-up and then the whole sample for a single program, returning the run durations (modes all and total)
+func MeasureProgram(cfg *Config, bytecode []byte, calldata []byte, opts ProgramOptions) (*DurationStats, error) {
+	out, results, jsonOut := opts.Out, opts.Results, opts.JSON
+	if out == nil {
+		out = io.Discard
+	}
+	epochs := opts.Epochs
+	if epochs < 1 {
+		epochs = 1
+	}
+
+	// the state all the runs start from, prepared once, as every run reverts its changes to it, see execute.
+	// Reverted as well once the program is measured, so that the next program on cfg starts from the state of NewConfig
+	snapshot := cfg.State.Snapshot()
+	defer cfg.State.RevertToSnapshot(snapshot)
+	prepareState(cfg, bytecode)
+
+	// Warm-up. **NOTE** we're keeping tracing on during warm-up, otherwise measurements are off
+	cfg.EVMConfig.Debug = false
+	var reuse *reusableExecution
+	if opts.ReuseEVM {
+		cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+		reuse = newReusableExecution(cfg, calldata)
+	}
+	var retWarmUp []byte
+	var errWarmUp error
+	// every run is cancelled once it runs longer than the timeout, the untimed ones as well, see startTimeout
+	cfg.timeout = opts.Timeout
+	defer func() { cfg.timeout = 0 }()
+	// the total duration of the warm-up, including the traced run, tells how long the priming took
+	warmUpStart := nanotime()
+	for i := 0; i < opts.Warmup; i++ {
+		startUnixNs := time.Now().UnixNano()
+		var tracer *haltTracer
+		if opts.ReportWarmUp && i == 0 {
+			retWarmUp, tracer, errWarmUp = executeTracingHalt(cfg, calldata)
+		} else if reuse != nil {
+			retWarmUp, _, _, _, errWarmUp = reuse.run()
+		} else {
+			cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+			retWarmUp, _, errWarmUp = execute(calldata, cfg)
+		}
+		if errors.Is(errWarmUp, ErrTimeout) {
+			fmt.Fprintf(cfg.Stderr, "Warm-up run timed out after %v, skipping the sample\n", opts.Timeout)
+			if epochs > 1 {
+				results = NewCSVPrefixWriter(results, "0,")
+			}
+			writeTimeoutCSV(results, startUnixNs)
+			return new(DurationStats), nil
+		}
+		if tracer != nil {
+			fmt.Fprintln(cfg.Stderr, "Halted by:", tracer.haltReason(bytecode, errWarmUp))
+			tracer.writeCreations(cfg.Stderr)
+		}
+	}
+	fmt.Fprintf(cfg.Info, "Warm-up runs: %d, %v in total\n", opts.Warmup, time.Duration(nanotime()-warmUpStart))
+	if errWarmUp != nil && !opts.ContinueOnError {
+		printExecutionError(cfg.Stderr, retWarmUp, errWarmUp)
+		return new(DurationStats), fmt.Errorf("warm-up run failed: %w", errWarmUp)
+	}
+	if results != nil {
+		results = &csvSuffixWriter{writer: results, suffix: recordMemory(cfg, calldata)}
+	}
+	// End warm-up
+
+	var graph *flamegraph
+	if opts.Mode == "flamegraph" {
+		graph = newFlamegraph(cfg, calldata, opts)
+	}
+
+	if opts.GCMode == "off" {
+		go_runtime.GC()
+		defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	}
+
+	stats := new(DurationStats)
+	epochStats := make([]*DurationStats, epochs)
+	for epoch := range epochStats {
+		if epoch > 0 {
+			time.Sleep(opts.EpochPause)
+		}
+		epochStats[epoch] = new(DurationStats)
+		out, results, jsonOut := out, results, jsonOut
+		if epochs > 1 {
+			// tagged after the program index, if any
+			prefix := fmt.Sprintf("%d,", epoch)
+			out = NewCSVPrefixWriter(out, prefix)
+			if results != nil {
+				results = NewCSVPrefixWriter(results, prefix)
+			}
+			jsonOut = jsonOut.withEpoch(epoch)
+		}
+		for i := 0; !sampleDone(epochStats[epoch], i, opts.SampleSize, opts.TargetSEM, opts.MaxSamples); i++ {
+			if opts.GCMode == "each" {
+				go_runtime.GC()
+			}
+			var duration time.Duration
+			var ret []byte
+			var err error
+			if opts.Mode == "all" {
+				duration, ret, err = MeasureAll(cfg, bytecode, calldata, reuse, opts, out, results, jsonOut, i)
+			} else if opts.Mode == "total" {
+				duration, ret, err = MeasureTotal(cfg, bytecode, calldata, reuse, opts, out, results, jsonOut, i)
+			} else if opts.Mode == "trace" {
+				TraceBytecode(cfg, bytecode, calldata, opts.PrintCSV, opts.Trace, out, results, i)
+			} else if opts.Mode == "traceJSON" {
+				TraceBytecodeJSON(cfg, bytecode, calldata, opts.Trace, jsonOut, results, i)
+			} else if opts.Mode == "opcode" {
+				MeasureOpcodes(cfg, bytecode, calldata, opts, out, results, i)
+			} else if opts.Mode == "alloc" {
+				MeasureAllocations(cfg, bytecode, calldata, opts.PrintCSV, out, results, i)
+			} else if opts.Mode == "cycles" {
+				MeasureCycles(cfg, bytecode, calldata, opts.PrintCSV, out, results, i)
+			} else if opts.Mode == "histogram" {
+				MeasureHistogram(cfg, bytecode, calldata, opts.PrintCSV, out, results, i)
+			} else if opts.Mode == "gasprofile" {
+				MeasureGasProfile(cfg, bytecode, calldata, opts.PrintCSV, out, results, i)
+			} else if opts.Mode == "flamegraph" {
+				measureFlamegraph(cfg, bytecode, calldata, graph, results, i)
+			}
+			if errors.Is(err, ErrTimeout) {
+				// cut short, its result row has the status timeout
+				fmt.Fprintf(cfg.Stderr, "Run %d timed out after %v, left out of the run durations\n", i, opts.Timeout)
+			} else if opts.Mode == "all" || opts.Mode == "total" {
+				if epoch == 0 && i == 0 && opts.Warmup > 0 {
+					warnIfStateDependent(cfg, retWarmUp, errWarmUp, ret, err)
+				}
+				stats.add(duration)
+				epochStats[epoch].add(duration)
+				if opts.RunObserver != nil {
+					opts.RunObserver(duration)
+				}
+			}
+		}
+	}
+	if graph != nil && opts.PrintCSV {
+		// of all the runs, the epochs included
+		graph.write(out)
+	}
+	if epochs > 1 && (opts.Mode == "all" || opts.Mode == "total") {
+		writeEpochSummary(cfg.Stderr, epochStats)
+	}
+	if opts.TargetSEM > 0 {
+		fmt.Fprintf(cfg.Stderr, "Standard error of the mean: %v after %d runs, target %v\n", stats.StandardError(), stats.Count(), opts.TargetSEM)
+		if stats.StandardError() >= opts.TargetSEM {
+			fmt.Fprintln(cfg.Stderr, "Warning: the target standard error of the mean was not reached within", opts.MaxSamples, "runs")
+		}
+	}
+	if opts.Summary {
+		stats.writeSummary(cfg.Stderr, opts.HarnessOverhead)
+	}
+	printExecutionError(cfg.Stderr, retWarmUp, errWarmUp)
+	return stats, nil
+}
+
+// minAdaptiveSamples is the least number of runs the standard error of the mean is trusted after, see sampleDone
+const minAdaptiveSamples = 30
+
+// sampleDone tells if the sample is complete after the given number of runs: once it has sampleSize runs and,
+// if targetSEM is positive, the standard error of the mean is below it, after at least minAdaptiveSamples runs,
+// or it has maxSamples runs
+func sampleDone(stats *DurationStats, runs int, sampleSize int, targetSEM time.Duration, maxSamples int) bool {
+	if runs < sampleSize {
+		return false
+	}
+	if targetSEM <= 0 || runs >= maxSamples {
+		return true
+	}
+	return runs >= minAdaptiveSamples && stats.StandardError() < targetSEM
+}
+
+// DefaultTraceStackColumns is the default number of stack elements printed in every trace CSV row
+const DefaultTraceStackColumns = 32
+
+// TraceColumns configures the stack and the optional trace CSV columns, printed after the stack columns
+type TraceColumns struct {
+	// StackColumns is the number of stack elements printed, from the bottom of the stack, padded with empty columns.
+	// The stack_depth column has the full depth regardless
+	StackColumns int
+	// Memory adds the memory size in words
+	Memory bool
+	// MemoryLimit, if positive, adds up to that many first bytes of memory (hex)
+	MemoryLimit int
+	// OpNumeric adds the opcode as a decimal byte value
+	OpNumeric bool
+	// Storage adds the storage of the executing contract known to the tracer, captured at SLOAD and SSTORE steps only,
+	// see formatStorage
+	Storage bool
+	// StorageDelta limits the storage column to the slots changed since the previous step with storage
+	StorageDelta bool
+	// Branch adds whether a JUMP or JUMPI jumped and its destination, see formatBranch, to the rows of mode opcode as well
+	Branch bool
+}
+
+func TraceBytecode(cfg *Config, bytecode []byte, calldata []byte, printCSV bool, trace TraceColumns, out io.Writer, results io.Writer, sampleId int) {
+	tracerConfig := new(vm.LogConfig)
+	setDefaultTracerConfig(tracerConfig)
+	if trace.MemoryLimit > 0 {
+		// see setDefaultTracerConfig, capturing memory slows down the traced execution considerably
+		tracerConfig.EnableMemory = true
+	}
+	if trace.Storage {
+		tracerConfig.DisableStorage = false
+	}
+
+	tracer := vm.NewStructLogger(tracerConfig)
+	cfg.EVMConfig.Tracer = tracer
+	cfg.EVMConfig.Debug = true
+
+	startUnixNs := time.Now().UnixNano()
+	ret, leftOverGas, err := execute(calldata, cfg)
+	printExecutionError(cfg.Stderr, ret, err)
+	writeResultCSV(results, sampleId, startUnixNs, ret, err, len(tracer.StructLogs()), cfg.GasLimit, leftOverGas)
+
+	if printCSV {
+		logs := tracer.StructLogs()
+		var previousStorage map[common.Hash]common.Hash
+		for i, log := range logs {
+			fmt.Fprintf(out, "%d,%d,%v,%d,%d,%d", i, log.Pc, CSVField(log.Op.String()), log.Gas, log.GasCost, len(log.Stack))
+
+			// printing the stack, if there are not enough elems, append the csv with empty columns
+			for i := 0; i < trace.StackColumns; i++ {
+				if i < len(log.Stack) {
+					fmt.Fprintf(out, ",%d", log.Stack[i].ToBig())
+				} else {
+					fmt.Fprintf(out, ",")
+				}
+			}
+			if trace.Memory {
+				fmt.Fprintf(out, ",%d", (log.MemorySize+31)/32)
+			}
+			if trace.MemoryLimit > 0 {
+				memory := log.Memory
+				if len(memory) > trace.MemoryLimit {
+					memory = memory[:trace.MemoryLimit]
+				}
+				fmt.Fprintf(out, ",%x", memory)
+			}
+			if trace.OpNumeric {
+				// the mnemonic column stays, this is for consumers keying on the raw byte, also for unassigned opcodes
+				fmt.Fprintf(out, ",%d", byte(log.Op))
+			}
+			if trace.Storage {
+				fmt.Fprintf(out, ",%s", formatStorage(log.Storage, previousStorage))
+				if trace.StorageDelta && log.Storage != nil {
+					previousStorage = log.Storage
+				}
+			}
+			if trace.Branch {
+				var next *uint64
+				if i+1 < len(logs) {
+					next = &logs[i+1].Pc
+				}
+				fmt.Fprint(out, formatBranch(log.Op, log.Pc, next))
+			}
+			fmt.Fprintf(out, "\n")
+		}
+	}
+}
+
+// formatStorage formats the storage as space separated key=value hex pairs sorted by key, leaving out the slots of the same
+// value in previous, if not nil. The tracer captures storage at SLOAD and SSTORE steps only, elsewhere the column is empty
+func formatStorage(storage map[common.Hash]common.Hash, previous map[common.Hash]common.Hash) string {
+	keys := make([]common.Hash, 0, len(storage))
+	for key, value := range storage {
+		if previousValue, ok := previous[key]; !ok || previousValue != value {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%x=%x", key, storage[key])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// MeasureOpcodes times every executed opcode separately, see opcodeTimer. The rows are limited to the measured instructions of opts,
+// see ProgramOptions.MeasuredRange, with the branch columns of opts.Trace
+func MeasureOpcodes(cfg *Config, bytecode []byte, calldata []byte, opts ProgramOptions, out io.Writer, results io.Writer, sampleId int) {
+	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+	timer := new(opcodeTimer)
+	cfg.EVMConfig.Tracer = timer
+	cfg.EVMConfig.Debug = true
+
+	startUnixNs := time.Now().UnixNano()
+	ret, leftOverGas, err := execute(calldata, cfg)
+	printExecutionError(cfg.Stderr, ret, err)
+	writeResultCSV(results, sampleId, startUnixNs, ret, err, len(timer.timings), cfg.GasLimit, leftOverGas)
+
+	if opts.PrintCSV {
+		writeCSVOpcodeTimings(out, timer.timings, opts, sampleId)
+	}
+}
+
+// measureExecution runs and times the call with the reset instrumenter, see resetInstrumenter, or with the reused EVM, if given.
+// Returns the gas refund of the run as well, see executeTimed
+func measureExecution(cfg *Config, calldata []byte, reuse *reusableExecution) ([]byte, uint64, time.Duration, uint64, error) {
+	if reuse != nil {
+		return reuse.run()
+	}
+	resetInstrumenter(cfg)
+	return executeTimed(calldata, cfg)
+}
+
+// MeasureAllocations counts the heap allocations done by the run.
+// Reading the MemStats stops the world, so this mode is not timed at all.
+func MeasureAllocations(cfg *Config, bytecode []byte, calldata []byte, printCSV bool, out io.Writer, results io.Writer, sampleId int) {
+	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+
+	var before, after go_runtime.MemStats
+	startUnixNs := time.Now().UnixNano()
+	go_runtime.ReadMemStats(&before)
+	ret, leftOverGas, err := execute(calldata, cfg)
+	go_runtime.ReadMemStats(&after)
+
+	printExecutionError(cfg.Stderr, ret, err)
+	writeResultCSV(results, sampleId, startUnixNs, ret, err, len(cfg.EVMConfig.Instrumenter.Logs), cfg.GasLimit, leftOverGas)
+
+	if printCSV {
+		fmt.Fprintf(out, "%d,%d,%d\n", sampleId, after.Mallocs-before.Mallocs, after.TotalAlloc-before.TotalAlloc)
+	}
+}
+
+// MeasureCycles counts the TSC cycles of the run, see readTSC
+func MeasureCycles(cfg *Config, bytecode []byte, calldata []byte, printCSV bool, out io.Writer, results io.Writer, sampleId int) {
+	resetInstrumenter(cfg)
+
+	startUnixNs := time.Now().UnixNano()
+	start := readTSC()
+	ret, leftOverGas, err := execute(calldata, cfg)
+	cycles := readTSC() - start
+
+	printExecutionError(cfg.Stderr, ret, err)
+	writeResultCSV(results, sampleId, startUnixNs, ret, err, len(cfg.EVMConfig.Instrumenter.Logs), cfg.GasLimit, leftOverGas)
+
+	if printCSV {
+		fmt.Fprintf(out, "%d,%d\n", sampleId, cycles)
+	}
+}
+
+// EstimateTSCFrequency measures the rate of readTSC against the clock over a short sleep, in Hz
+func EstimateTSCFrequency() float64 {
+	startNano, startTSC := nanotime(), readTSC()
+	time.Sleep(100 * time.Millisecond)
+	return float64(readTSC()-startTSC) / float64(nanotime()-startNano) * 1e9
+}
+
+// MeasureTotal returns the duration of the run, which is timed around the instrumented execution, see ProgramOptions.PrintEach and PrintCSV,
+// along with its return data and error
+func MeasureTotal(cfg *Config, bytecode []byte, calldata []byte, reuse *reusableExecution, opts ProgramOptions, out io.Writer, results io.Writer, jsonOut *JSONWriter, sampleId int) (time.Duration, []byte, error) {
+	// We're not collecting in between runs anymore. If the pressure on memory is OK, this has been chosen as the best approach.
+	// (Assuming GOGC=off, which is well enough aligned with default go GC behavior).
+	// Collecting before every run is still available with -gcMode each.
+
+	startUnixNs := time.Now().UnixNano()
+	ret, leftOverGas, duration, refund, err := measureExecution(cfg, calldata, reuse)
+
+	printExecutionError(cfg.Stderr, ret, err)
+	writeResultCSV(results, sampleId, startUnixNs, ret, err, len(cfg.EVMConfig.Instrumenter.Logs), cfg.GasLimit, leftOverGas)
+	capped := cappedRefund(cfg, refund, leftOverGas)
+	if opts.PrintEach {
+		printDuration(cfg, opts, duration)
+		printGas(cfg, leftOverGas)
+		printRefund(cfg, refund, capped)
+	}
+
+	if opts.PrintCSV {
+		vm.WriteCSVInstrumentationTotal(out, cfg.EVMConfig.Instrumenter, sampleId)
+	}
+	jsonOut.write(cfg.Stderr, jsonSample{SampleId: sampleId, Status: ErrorStatus(err), GasUsed: cfg.GasLimit - leftOverGas, GasLeft: leftOverGas, DurationNs: duration.Nanoseconds(), CalibratedDurationNs: opts.calibratedNs(duration), Refund: refund, CappedRefund: capped, Instrumenter: cfg.EVMConfig.Instrumenter})
+	return duration, ret, err
+}
+
+// MeasureAll returns the duration of the run, along with its return data and error. With opts.Aggregate, the CSV has per-opcode aggregates, see writeCSVAggregate.
+// The instrumentation is limited to the measured instructions of opts, see ProgramOptions.MeasuredRange
+func MeasureAll(cfg *Config, bytecode []byte, calldata []byte, reuse *reusableExecution, opts ProgramOptions, out io.Writer, results io.Writer, jsonOut *JSONWriter, sampleId int) (time.Duration, []byte, error) {
+	// see above
+
+	startUnixNs := time.Now().UnixNano()
+	ret, leftOverGas, duration, refund, err := measureExecution(cfg, calldata, reuse)
+
+	printExecutionError(cfg.Stderr, ret, err)
+	writeResultCSV(results, sampleId, startUnixNs, ret, err, len(cfg.EVMConfig.Instrumenter.Logs), cfg.GasLimit, leftOverGas)
+	capped := cappedRefund(cfg, refund, leftOverGas)
+	if opts.PrintCSV {
+		instrumenterLogs := opts.measuredLogs(cfg.EVMConfig.Instrumenter.Logs)
+		if opts.Aggregate {
+			writeCSVAggregate(out, instrumenterLogs, sampleId)
+		} else {
+			vm.WriteCSVInstrumentationAll(out, instrumenterLogs, sampleId)
+		}
+	}
+	jsonOut.write(cfg.Stderr, jsonSample{SampleId: sampleId, Status: ErrorStatus(err), GasUsed: cfg.GasLimit - leftOverGas, GasLeft: leftOverGas, DurationNs: duration.Nanoseconds(), CalibratedDurationNs: opts.calibratedNs(duration), Refund: refund, CappedRefund: capped, Measurements: opts.measuredLogs(cfg.EVMConfig.Instrumenter.Logs)})
+
+	// last, well after the timed execution, as formatting the whole instrumentation of every run is costly
+	if opts.PrintEach {
+		printDuration(cfg, opts, duration)
+		fmt.Fprintln(cfg.Info, "Executed opcodes:", len(cfg.EVMConfig.Instrumenter.Logs))
+		printGas(cfg, leftOverGas)
+		printRefund(cfg, refund, capped)
+
+		instrumenterLogs := opts.measuredLogs(cfg.EVMConfig.Instrumenter.Logs)
+		vm.WriteInstrumentation(cfg.Info, instrumenterLogs)
+	}
+	return duration, ret, err
+}
+
+// printDuration prints the duration of a run and, with a harness overhead, the calibrated duration next to it
+func printDuration(cfg *Config, opts ProgramOptions, duration time.Duration) {
+	fmt.Fprintln(cfg.Info, "Run duration:", duration)
+	if opts.HarnessOverhead > 0 {
+		fmt.Fprintln(cfg.Info, "Calibrated run duration:", duration-opts.HarnessOverhead)
+	}
+}
+
+// calibratedNs is the duration of a run less the harness overhead, nil without calibration, see ProgramOptions.HarnessOverhead
+func (opts ProgramOptions) calibratedNs(duration time.Duration) *int64 {
+	if opts.HarnessOverhead <= 0 {
+		return nil
+	}
+	calibrated := (duration - opts.HarnessOverhead).Nanoseconds()
+	return &calibrated
+}
+
+// printGas prints the gas used by a run, the gas limit less the gas left over, and the gas left over
+func printGas(cfg *Config, leftOverGas uint64) {
+	fmt.Fprintf(cfg.Info, "Gas used: %d, gas left: %d\n", cfg.GasLimit-leftOverGas, leftOverGas)
+}
+
+// printRefund prints the gas refund of a run, if any, see cappedRefund
+func printRefund(cfg *Config, refund uint64, capped uint64) {
+	if refund > 0 {
+		fmt.Fprintf(cfg.Info, "Gas refund: %d, capped by the gas used: %d\n", refund, capped)
+	}
+}
+
+// jsonSample is a single measured run printed as a JSON line
+type jsonSample struct {
+	Label                string                 `json:"label,omitempty"`
+	CodeHash             string                 `json:"codeHash,omitempty"`
+	ProgramId            *int                   `json:"programId,omitempty"`
+	Epoch                *int                   `json:"epoch,omitempty"`
+	SampleId             int                    `json:"sampleId"`
+	Status               string                 `json:"status,omitempty"`
+	GasUsed              uint64                 `json:"gasUsed"`
+	GasLeft              uint64                 `json:"gasLeft"`
+	DurationNs           int64                  `json:"durationNs,omitempty"`
+	CalibratedDurationNs *int64                 `json:"calibratedDurationNs,omitempty"`
+	Refund               uint64                 `json:"refund,omitempty"`
+	CappedRefund         uint64                 `json:"cappedRefund,omitempty"`
+	Measurements         []vm.InstrumenterLog   `json:"measurements,omitempty"`
+	Instrumenter         *vm.InstrumenterLogger `json:"instrumenter,omitempty"`
+}
+
+// JSONWriter prints samples as JSON lines, tagging them with the program index in batch mode
+// and the label and code hash of the program, if any. A nil JSONWriter prints nothing
+type JSONWriter struct {
+	encoder   *json.Encoder
+	label     string
+	codeHash  string
+	programId *int
+	epoch     *int
+}
+
+// NewJSONWriter prints samples to out, tagging them with the programId, if not nil
+func NewJSONWriter(out io.Writer, programId *int) *JSONWriter {
+	return &JSONWriter{encoder: json.NewEncoder(out), programId: programId}
+}
+
+// write prints the sample, reporting a failure to stderr
+func (w *JSONWriter) write(stderr io.Writer, sample jsonSample) {
+	if w == nil {
+		return
+	}
+	sample.Label = w.label
+	sample.CodeHash = w.codeHash
+	sample.ProgramId = w.programId
+	sample.Epoch = w.epoch
+	if err := w.encoder.Encode(sample); err != nil {
+		fmt.Fprintln(stderr, "Unable to print JSON:", err)
+	}
+}
+
+// WithLabel returns a writer to the same output tagging samples with the label of the program as well
+func (w *JSONWriter) WithLabel(label string) *JSONWriter {
+	if w == nil {
+		return nil
+	}
+	tagged := *w
+	tagged.label = label
+	return &tagged
+}
+
+// WithCodeHash returns a writer to the same output tagging samples with the hash of the code of the program as well, see CodeHash
+func (w *JSONWriter) WithCodeHash(codeHash string) *JSONWriter {
+	if w == nil {
+		return nil
+	}
+	tagged := *w
+	tagged.codeHash = codeHash
+	return &tagged
+}
+
+// withEpoch returns a writer to the same output tagging samples with the epoch as well, see MeasureProgram
+func (w *JSONWriter) withEpoch(epoch int) *JSONWriter {
+	if w == nil {
+		return nil
+	}
+	tagged := *w
+	tagged.epoch = &epoch
+	return &tagged
+}
+
+func (w *JSONWriter) writeStep(stderr io.Writer, step structLogRes) {
+	if w == nil {
+		return
+	}
+	step.Label = w.label
+	step.CodeHash = w.codeHash
+	step.ProgramId = w.programId
+	if err := w.encoder.Encode(step); err != nil {
+		fmt.Fprintln(stderr, "Unable to print JSON:", err)
+	}
+}
@@ -0,0 +1,68 @@
+package measure
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCSVHeader(t *testing.T) {
+	tests := []struct {
+		mode      string
+		trace     TraceColumns
+		aggregate bool
+		tagColumn string
+		epochs    bool
+		expected  string
+	}{
+		{"total", TraceColumns{}, false, "", false, "run_id,measure_total_time_ns,measure_total_timer_time_ns"},
+		{"all", TraceColumns{}, false, "program_id", true, "program_id,epoch,run_id,instruction_id,measure_all_time_ns,measure_all_timer_time_ns"},
+		{"all", TraceColumns{}, true, "", false, "run_id,op,count,measure_all_time_ns,mean_measure_all_time_ns"},
+		{"trace", TraceColumns{StackColumns: 2, Memory: true, OpNumeric: true}, false, "", false,
+			"instruction_id,pc,op,gas,gas_cost,stack_depth,stack_0,stack_1,memory_words,op_byte"},
+		{"opcode", TraceColumns{Branch: true}, false, "label", false, "label,run_id,instruction_id,pc,op,time_ns,jump_taken,jump_destination"},
+		{"disasm", TraceColumns{}, false, "", false, "pc,op,immediate"},
+	}
+	for _, test := range tests {
+		if header := CSVHeader(test.mode, test.trace, test.aggregate, test.tagColumn, test.epochs); header != test.expected {
+			t.Errorf("mode %v: header %v, expected %v", test.mode, header, test.expected)
+		}
+	}
+}
+
+// TestCSVHeaderMatchesRows checks that the header has a column for every field of the rows the mode prints, in the modes
+// which rows are written by this package rather than by the instrumenter of go-ethereum
+func TestCSVHeaderMatchesRows(t *testing.T) {
+	tests := []struct {
+		mode      string
+		trace     TraceColumns
+		aggregate bool
+	}{
+		{"all", TraceColumns{}, true},
+		{"trace", TraceColumns{StackColumns: 3, Memory: true, OpNumeric: true, Branch: true}, false},
+		{"opcode", TraceColumns{}, false},
+		{"histogram", TraceColumns{}, false},
+	}
+	// PUSH1 1 PUSH1 2 ADD POP STOP
+	bytecode := []byte{0x60, 0x01, 0x60, 0x02, 0x01, 0x50, 0x00}
+	for _, test := range tests {
+		var out bytes.Buffer
+		_, err := MeasureProgram(newTestConfig(t, "london"), bytecode, nil, ProgramOptions{
+			Mode:       test.mode,
+			SampleSize: 1,
+			PrintCSV:   true,
+			Aggregate:  test.aggregate,
+			Trace:      test.trace,
+			Out:        &out,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns := len(strings.Split(CSVHeader(test.mode, test.trace, test.aggregate, "", false), ","))
+		for _, row := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if fields := len(strings.Split(row, ",")); fields != columns {
+				t.Errorf("mode %v: row %v of %d fields, the header has %d columns", test.mode, row, fields, columns)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package measure
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// memoryTracer is a vm.EVMLogger counting the expansions of memory and its peak size in words, in all frames.
+// Every frame has a memory of its own, so the sizes are tracked by the depth of the frame.
+// An expansion is seen at the step following the expanding one, so an expansion by the last step of a frame,
+// e.g. RETURN or a call out of range of the memory, is not counted. The depth of the deepest frame is kept too, 1 if the
+// execution stayed in the frame of the bytecode
+type memoryTracer struct {
+	// sizes of the memory of the active frames, by depth
+	sizes      []int
+	expansions int
+	peakWords  int
+	maxDepth   int
+}
+
+func (t *memoryTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	for len(t.sizes) < depth {
+		t.sizes = append(t.sizes, 0)
+	}
+	// frames deeper than this one have returned
+	t.sizes = t.sizes[:depth]
+	if depth > t.maxDepth {
+		t.maxDepth = depth
+	}
+	size := scope.Memory.Len()
+	if size > t.sizes[depth-1] {
+		t.expansions++
+		t.sizes[depth-1] = size
+	}
+	if words := (size + 31) / 32; words > t.peakWords {
+		t.peakWords = words
+	}
+}
+
+func (t *memoryTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+func (t *memoryTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {}
+
+func (t *memoryTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+func (t *memoryTracer) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+func (t *memoryTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+// recordMemory runs the bytecode once with the memoryTracer, untimed, and returns the result CSV columns with its number of memory
+// expansions, peak memory size in words and maximum call depth. Runs of the same program start from the same state, so these
+// hold for every run
+func recordMemory(cfg *Config, calldata []byte) string {
+	cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+	tracer := new(memoryTracer)
+	cfg.EVMConfig.Tracer = tracer
+	cfg.EVMConfig.Debug = true
+	defer func() {
+		cfg.EVMConfig.Tracer = nil
+		cfg.EVMConfig.Debug = false
+	}()
+
+	execute(calldata, cfg)
+	fmt.Fprintf(cfg.Info, "Memory expansions: %d, peak memory size: %d words, maximum call depth: %d\n", tracer.expansions, tracer.peakWords, tracer.maxDepth)
+	return fmt.Sprintf(",%d,%d,%d", tracer.expansions, tracer.peakWords, tracer.maxDepth)
+}
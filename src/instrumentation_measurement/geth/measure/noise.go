@@ -0,0 +1,37 @@
+package measure
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// DefaultNoiseSampleSize is the sample size of mode noise, unless given
+const DefaultNoiseSampleSize = 10000
+
+// noiseQuietRatio is the largest p99 of the durations of mode noise, relative to the median, of a quiet environment
+const noiseQuietRatio = 1.5
+
+// WriteNoiseCSV writes the distribution of the durations of the empty program, measured by Calibrate in mode total,
+// as a single row: the number of runs, min, median, p99, max, mean and standard deviation in nanoseconds (mode noise)
+func WriteNoiseCSV(out io.Writer, stats *DurationStats) {
+	fmt.Fprintf(out, "%d,%d,%d,%d,%d,%d,%.0f\n", stats.Count(), stats.Min().Nanoseconds(), stats.Percentile(50).Nanoseconds(),
+		stats.Percentile(99).Nanoseconds(), stats.Max().Nanoseconds(), stats.Mean().Nanoseconds(), math.Sqrt(stats.Variance()))
+}
+
+// WriteNoiseSummary writes the distribution of the durations of mode noise, and whether the environment is quiet enough
+// for the estimation of single opcodes: the p99 at most noiseQuietRatio times the median, i.e. rare outliers only.
+// Returns that verdict
+func WriteNoiseSummary(out io.Writer, stats *DurationStats) bool {
+	median := stats.Percentile(50)
+	fmt.Fprintf(out, "Harness noise of %d runs of STOP: min %v, median %v, p99 %v, max %v, mean %v, standard deviation %v\n",
+		stats.Count(), stats.Min(), median, stats.Percentile(99), stats.Max(), stats.Mean(), time.Duration(math.Sqrt(stats.Variance())))
+	quiet := float64(stats.Percentile(99)) <= noiseQuietRatio*float64(median)
+	if quiet {
+		fmt.Fprintf(out, "Quiet: the p99 is within %v times the median, single opcodes can be estimated\n", noiseQuietRatio)
+	} else {
+		fmt.Fprintf(out, "Noisy: the p99 is over %v times the median, single opcode estimates are unreliable in this environment\n", noiseQuietRatio)
+	}
+	return quiet
+}
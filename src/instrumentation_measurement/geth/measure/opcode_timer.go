@@ -0,0 +1,102 @@
+package measure
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// opcodeTiming is the wall-clock time of a single executed opcode, along with the gas charged for it
+type opcodeTiming struct {
+	pc      uint64
+	op      vm.OpCode
+	gasCost uint64
+	timeNs  int64
+	depth   int
+}
+
+// opcodeTimer is a vm.EVMLogger timing every opcode step.
+// The time of an opcode is taken in between consecutive CaptureState calls (hooked in right before every opcode),
+// so it also includes some interpreter loop and tracer overhead.
+type opcodeTimer struct {
+	timings []opcodeTiming
+	started bool
+	pc      uint64
+	op      vm.OpCode
+	gasCost uint64
+	depth   int
+	start   int64
+}
+
+func (t *opcodeTimer) stop(now int64) {
+	if t.started {
+		t.timings = append(t.timings, opcodeTiming{pc: t.pc, op: t.op, gasCost: t.gasCost, timeNs: now - t.start, depth: t.depth})
+		t.started = false
+	}
+}
+
+func (t *opcodeTimer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	now := nanotime()
+	t.stop(now)
+	t.started = true
+	t.pc = pc
+	t.op = op
+	t.gasCost = cost
+	t.depth = depth
+	// take the start again, to leave the bookkeeping above out of the measurement
+	t.start = nanotime()
+}
+
+func (t *opcodeTimer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {
+	t.stop(nanotime())
+}
+
+func (t *opcodeTimer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+func (t *opcodeTimer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+func (t *opcodeTimer) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+func (t *opcodeTimer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+// writeCSVOpcodeTimings writes a row per executed opcode measured by opts (see ProgramOptions.MeasuredRange): sampleId, instruction index,
+// pc, op and the time in nanoseconds, followed by the branch columns, if opts.Trace.Branch is set, see formatBranch
+func writeCSVOpcodeTimings(out io.Writer, timings []opcodeTiming, opts ProgramOptions, sampleId int) {
+	instructionId := 0
+	for i, timing := range timings {
+		if !opts.measuredPc(timing.pc) {
+			continue
+		}
+		fmt.Fprintf(out, "%d,%d,%d,%v,%d", sampleId, instructionId, timing.pc, CSVField(timing.op.String()), timing.timeNs)
+		if opts.Trace.Branch {
+			var next *uint64
+			if i+1 < len(timings) {
+				next = &timings[i+1].pc
+			}
+			fmt.Fprint(out, formatBranch(timing.op, timing.pc, next))
+		}
+		fmt.Fprintln(out)
+		instructionId++
+	}
+}
+
+// formatBranch formats the jump_taken (1 or 0) and jump_destination columns of a step, told from the pc of the next step,
+// nil if there is none: a JUMP or JUMPI jumped if the next step is not at the following pc, the destination is that of the next step.
+// Both columns are empty for other opcodes and for a jump without a next step (e.g. to an invalid destination), the destination
+// is empty for a JUMPI which did not jump. A JUMPI to the following pc counts as not taken
+func formatBranch(op vm.OpCode, pc uint64, next *uint64) string {
+	if (op != vm.JUMP && op != vm.JUMPI) || next == nil {
+		return ",,"
+	}
+	if *next == pc+1 {
+		return ",0,"
+	}
+	return fmt.Sprintf(",1,%d", *next)
+}
@@ -0,0 +1,94 @@
+package measure
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// precompileNames are the names PrecompileAddress takes, by their addresses. The BLS12-381 ones (EIP-2537) are implemented
+// by the go-ethereum version in use, yet active under none of its forks
+var precompileNames = []string{
+	"ecrecover", "sha256", "ripemd160", "identity", "modexp", "bn256Add", "bn256ScalarMul", "bn256Pairing", "blake2f",
+	"bls12381G1Add", "bls12381G1Mul", "bls12381G1MultiExp", "bls12381G2Add", "bls12381G2Mul", "bls12381G2MultiExp",
+	"bls12381Pairing", "bls12381MapG1", "bls12381MapG2",
+}
+
+// PrecompileAddress returns the address of a precompiled contract given by its name (case-insensitive, see precompileNames)
+// or by its address (hex)
+func PrecompileAddress(precompile string) (common.Address, error) {
+	for i, name := range precompileNames {
+		if strings.EqualFold(name, precompile) {
+			return common.BytesToAddress([]byte{byte(i + 1)}), nil
+		}
+	}
+	if !common.IsHexAddress(precompile) && !strings.HasPrefix(precompile, "0x") {
+		return common.Address{}, fmt.Errorf("unknown precompile %v, available: %v or an address", precompile, strings.Join(precompileNames, ", "))
+	}
+	address := common.HexToAddress(precompile)
+	_, berlin := vm.PrecompiledContractsBerlin[address]
+	if _, bls := vm.PrecompiledContractsBLS[address]; !berlin && !bls {
+		return common.Address{}, fmt.Errorf("no precompile at %v", address)
+	}
+	return address, nil
+}
+
+// PrecompileInput returns an input of the given size, the input repeated (and truncated), or random bytes of the source
+// of program generation (see Seed), if the input is empty. The cost of SHA256, RIPEMD160, IDENTITY and MODEXP depends on the size
+func PrecompileInput(input []byte, size int) []byte {
+	sized := make([]byte, size)
+	if len(input) == 0 {
+		random.Read(sized)
+		return sized
+	}
+	for i := range sized {
+		sized[i] = input[i%len(input)]
+	}
+	return sized
+}
+
+// PrecompileCall returns the code, starting at pc start, copying the input to memory (see memoryPrelude) and then calling
+// the precompile at the address with it, with all the gas and no value, repeat times. The output is not copied to memory,
+// the call results are popped. The call is CALL rather than STATICCALL, which Homestead has not
+func PrecompileCall(start int, address common.Address, input []byte, repeat int) ([]byte, error) {
+	code, err := memoryPrelude(start, input)
+	if err != nil {
+		return nil, err
+	}
+	// retSize, retOffset, argsSize, argsOffset, value, address, gas
+	call := []byte{byte(vm.PUSH1), 0, byte(vm.PUSH1), 0}
+	call = appendPush4(call, len(input))
+	call = append(call, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH20))
+	call = append(call, address.Bytes()...)
+	call = append(call, byte(vm.GAS), byte(vm.CALL), byte(vm.POP))
+	for i := 0; i < repeat; i++ {
+		code = append(code, call...)
+	}
+	return code, nil
+}
+
+// PrecompileGas returns the gas the precompile at the address charges for the input under the rules of the chain config,
+// as the interpreter would, and fails if the precompile is not active under them
+func PrecompileGas(chainConfig *params.ChainConfig, blockNumber *big.Int, address common.Address, input []byte) (uint64, error) {
+	rules := chainConfig.Rules(blockNumber, false)
+	var precompiles map[common.Address]vm.PrecompiledContract
+	switch {
+	case rules.IsBerlin:
+		precompiles = vm.PrecompiledContractsBerlin
+	case rules.IsIstanbul:
+		precompiles = vm.PrecompiledContractsIstanbul
+	case rules.IsByzantium:
+		precompiles = vm.PrecompiledContractsByzantium
+	default:
+		precompiles = vm.PrecompiledContractsHomestead
+	}
+	precompile, ok := precompiles[address]
+	if !ok {
+		return 0, fmt.Errorf("precompile %v is not active under the fork", address)
+	}
+	return precompile.RequiredGas(input), nil
+}
@@ -0,0 +1,92 @@
+package measure
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPrecompileAddress(t *testing.T) {
+	tests := []struct {
+		precompile string
+		address    byte
+		fails      bool
+	}{
+		{"ecrecover", 0x01, false},
+		{"SHA256", 0x02, false},
+		{"identity", 0x04, false},
+		{"blake2f", 0x09, false},
+		{"bls12381G1Add", 0x0a, false},
+		{"0x05", 0x05, false},
+		{"0x0000000000000000000000000000000000000008", 0x08, false},
+		{"0x42", 0, true},
+		{"keccak", 0, true},
+	}
+	for _, test := range tests {
+		address, err := PrecompileAddress(test.precompile)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%v: expected an error, got %v", test.precompile, address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.precompile, err)
+		} else if expected := common.BytesToAddress([]byte{test.address}); address != expected {
+			t.Errorf("%v: address %v, expected %v", test.precompile, address, expected)
+		}
+	}
+}
+
+func TestPrecompileInput(t *testing.T) {
+	tests := []struct {
+		input    []byte
+		size     int
+		expected []byte
+	}{
+		{[]byte{0x01, 0x02}, 5, []byte{0x01, 0x02, 0x01, 0x02, 0x01}},
+		{[]byte{0x01, 0x02, 0x03}, 2, []byte{0x01, 0x02}},
+		{[]byte{0x01}, 0, []byte{}},
+	}
+	for _, test := range tests {
+		if sized := PrecompileInput(test.input, test.size); !bytes.Equal(sized, test.expected) {
+			t.Errorf("%x of size %d: %x, expected %x", test.input, test.size, sized, test.expected)
+		}
+	}
+	if sized := PrecompileInput(nil, 64); len(sized) != 64 {
+		t.Errorf("random input of %d bytes, expected 64", len(sized))
+	}
+}
+
+func TestPrecompileCall(t *testing.T) {
+	identity, err := PrecompileAddress("identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		start  int
+		input  []byte
+		repeat int
+	}{
+		{0, []byte{0x01, 0x02, 0x03}, 1},
+		{0, bytes.Repeat([]byte{0xab}, 100), 3},
+		{4, []byte{0xff}, 2},
+	}
+	for _, test := range tests {
+		call, err := PrecompileCall(test.start, identity, test.input, test.repeat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the output of the last call is the return data left after it:
+		// RETURNDATASIZE PUSH1 0 PUSH1 0 RETURNDATACOPY RETURNDATASIZE PUSH1 0 RETURN
+		code := append(bytes.Repeat([]byte{0x5b}, test.start), call...)
+		code = append(code, 0x3d, 0x60, 0x00, 0x60, 0x00, 0x3e, 0x3d, 0x60, 0x00, 0xf3)
+		if ret := executeOnce(t, code); !bytes.Equal(ret, test.input) {
+			t.Errorf("start %d, input %x: output %x of the identity", test.start, test.input, ret)
+		}
+		if calls := bytes.Count(call, []byte{0x5a, 0xf1, 0x50}); calls != test.repeat {
+			t.Errorf("start %d, input %x: %d calls (GAS CALL POP), expected %d", test.start, test.input, calls, test.repeat)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package measure
+
+import "math/rand"
+
+// random is the source of any program generation done in the harness, see Seed
+var random = rand.New(rand.NewSource(1))
+
+// Seed reseeds the source of program generation, so that the same seed generates the same programs
+func Seed(seed int64) {
+	random.Seed(seed)
+}
@@ -0,0 +1,179 @@
+package measure
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// PcRange is a range of pcs of the executed code, both ends included
+type PcRange struct {
+	Start uint64
+	End   uint64
+}
+
+// openEnd is the end of a range to the end of the code, whichever its length, see RangeFrom
+const openEnd = math.MaxUint64
+
+// RangeFrom returns the range of the instructions from pc start to the end of the code, whichever its length, so that it leaves out
+// the start bytes in front of programs of different lengths, e.g. a prelude
+func RangeFrom(start uint64) *PcRange {
+	return &PcRange{Start: start, End: openEnd}
+}
+
+// ReadTracePcs reads the pcs and ops of the rows of a trace CSV (mode trace), those of the pc and op columns of its header,
+// or the second and third columns, if it has none, as printed without tag columns. # lines are skipped
+func ReadTracePcs(in io.Reader) (map[uint64]vm.OpCode, error) {
+	reader := csv.NewReader(bufio.NewReader(in))
+	reader.Comment = '#'
+	// the number of columns varies with the stack depth of the rows
+	reader.FieldsPerRecord = -1
+	pcColumn, opColumn := 1, 2
+	pcs := make(map[uint64]vm.OpCode)
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row == 1 {
+			header := false
+			for column, name := range record {
+				switch strings.TrimSpace(name) {
+				case "pc":
+					pcColumn, header = column, true
+				case "op":
+					opColumn = column
+				}
+			}
+			if header {
+				continue
+			}
+		}
+		if len(record) <= pcColumn || len(record) <= opColumn {
+			return nil, fmt.Errorf("row %d has no pc and op columns", row)
+		}
+		pc, err := strconv.ParseUint(strings.TrimSpace(record[pcColumn]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid pc: %v", row, err)
+		}
+		name := strings.TrimSpace(record[opColumn])
+		op := vm.StringToOp(name)
+		if op == vm.STOP && name != "STOP" {
+			return nil, fmt.Errorf("row %d: invalid op %q", row, name)
+		}
+		pcs[pc] = op
+	}
+	if len(pcs) == 0 {
+		return nil, fmt.Errorf("no rows")
+	}
+	return pcs, nil
+}
+
+// ValidatePcs fails if any of the pcs is not that of an instruction of the bytecode with the same op, i.e. the trace the pcs
+// come from is not one of this bytecode
+func ValidatePcs(bytecode []byte, pcs map[uint64]vm.OpCode) error {
+	ops := make(map[uint64]vm.OpCode)
+	it := asm.NewInstructionIterator(bytecode)
+	for it.Next() {
+		ops[it.PC()] = it.Op()
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	for pc, op := range pcs {
+		// right past the end of the code, the interpreter runs a STOP
+		if actual, ok := ops[pc]; (ok && actual != op) || (!ok && (op != vm.STOP || pc != uint64(len(bytecode)))) {
+			return fmt.Errorf("the trace has %v at pc %d, which is not an instruction of the bytecode", op, pc)
+		}
+	}
+	return nil
+}
+
+// Validate fails if either end of the range is not the pc of an instruction of the bytecode, i.e. past its end or within
+// the immediate of a PUSH, or if the range is empty. The end of a range to the end of the code is not checked, see RangeFrom
+func (r *PcRange) Validate(bytecode []byte) error {
+	if r.Start > r.End {
+		return fmt.Errorf("range %d:%d ends before it starts", r.Start, r.End)
+	}
+	startFound, endFound := false, false
+	it := asm.NewInstructionIterator(bytecode)
+	for it.Next() {
+		startFound = startFound || it.PC() == r.Start
+		endFound = endFound || it.PC() == r.End || r.End == openEnd
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	for _, pc := range []struct {
+		name  string
+		pc    uint64
+		found bool
+	}{{"start", r.Start, startFound}, {"end", r.End, endFound}} {
+		if pc.pc >= uint64(len(bytecode)) && pc.pc != openEnd {
+			return fmt.Errorf("range %v %d is past the end of the bytecode of %d bytes", pc.name, pc.pc, len(bytecode))
+		}
+		if !pc.found {
+			return fmt.Errorf("range %v %d is not on an instruction boundary, but within the immediate of a PUSH", pc.name, pc.pc)
+		}
+	}
+	return nil
+}
+
+// CodeRange returns the range of the instructions of the bytecode from pc start to its end
+func CodeRange(bytecode []byte, start uint64) (*PcRange, error) {
+	r := &PcRange{Start: start}
+	found := false
+	it := asm.NewInstructionIterator(bytecode)
+	for it.Next() {
+		if it.PC() >= start {
+			r.End, found = it.PC(), true
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("no instructions from pc %d", start)
+	}
+	return r, nil
+}
+
+func (r *PcRange) contains(pc uint64) bool {
+	return r == nil || (pc >= r.Start && pc <= r.End)
+}
+
+// measuredPc tells if the instruction at the pc is within the MeasuredRange and MeasuredPcs of opts
+func (opts ProgramOptions) measuredPc(pc uint64) bool {
+	if !opts.MeasuredRange.contains(pc) {
+		return false
+	}
+	if opts.MeasuredPcs != nil {
+		_, ok := opts.MeasuredPcs[pc]
+		return ok
+	}
+	return true
+}
+
+// measuredLogs are the instrumenter logs within the MeasuredRange and MeasuredPcs of opts, all of them if neither is set
+func (opts ProgramOptions) measuredLogs(logs []vm.InstrumenterLog) []vm.InstrumenterLog {
+	if opts.MeasuredRange == nil && opts.MeasuredPcs == nil {
+		return logs
+	}
+	var measured []vm.InstrumenterLog
+	for _, log := range logs {
+		if opts.measuredPc(log.Pc) {
+			measured = append(measured, log)
+		}
+	}
+	return measured
+}
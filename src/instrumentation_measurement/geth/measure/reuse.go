@@ -0,0 +1,43 @@
+package measure
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+)
+
+// reusableExecution is an EVM built once for the bytecode and reused across all runs (see Options.ReuseEVM),
+// so that only the call is run anew and timed, the same call as that of execute, transferring the value and reverting on error.
+// The EVM holds a copy of the vm.Config it was built with, so it logs to the instrumenter of cfg at that moment, whatever the untimed
+// runs in between (see recordMemory, executeTracingHalt) set in cfg since
+type reusableExecution struct {
+	cfg          *Config
+	evm          *vm.EVM
+	instrumenter *vm.InstrumenterLogger
+	calldata     []byte
+}
+
+// newReusableExecution builds the EVM for the calls to the contract in the state prepared by prepareState. The instrumenter
+// in cfg at this moment (a new one, if none) is kept for all runs
+func newReusableExecution(cfg *Config, calldata []byte) *reusableExecution {
+	if cfg.EVMConfig.Instrumenter == nil {
+		cfg.EVMConfig.Instrumenter = vm.NewInstrumenterLogger()
+	}
+	return &reusableExecution{cfg: cfg, evm: runtime.NewEnv(cfg.Config), instrumenter: cfg.EVMConfig.Instrumenter, calldata: calldata}
+}
+
+// run calls the contract once more and returns the duration of the call alone, and its gas refund, see callTimed.
+// Changes to the state are reverted afterwards, so that every run starts from the same state.
+func (e *reusableExecution) run() ([]byte, uint64, time.Duration, uint64, error) {
+	// put back the instrumenter of the EVM, so that the logs of the run are read from it
+	e.cfg.EVMConfig.Instrumenter = e.instrumenter
+	resetInstrumenter(e.cfg)
+	ret, leftOverGas, duration, refund, err := callTimed(e.evm, e.calldata, e.cfg)
+	if errors.Is(err, ErrTimeout) {
+		// a cancelled EVM aborts every later call at its first jump, see startTimeout
+		e.evm = runtime.NewEnv(e.cfg.Config)
+	}
+	return ret, leftOverGas, duration, refund, err
+}
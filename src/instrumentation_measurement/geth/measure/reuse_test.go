@@ -0,0 +1,107 @@
+package measure
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// measureReused runs a small sample of the bytecode in mode all, with -aggregate, -resultCSV and -timeout, which all run the
+// bytecode untimed in between, and returns the aggregate rows and the result rows, less their cpu and start_unix_ns columns,
+// and less the times, which differ between runs
+func measureReused(t *testing.T, bytecode []byte, reuseEVM bool) (string, string) {
+	t.Helper()
+	cfg := newTestConfig(t, "london")
+	var out, results bytes.Buffer
+	_, err := MeasureProgram(cfg, bytecode, nil, ProgramOptions{
+		Mode:         "all",
+		ReuseEVM:     reuseEVM,
+		Warmup:       1,
+		Timeout:      time.Second,
+		ReportWarmUp: true,
+		SampleSize:   3,
+		PrintCSV:     true,
+		Aggregate:    true,
+		Out:          &out,
+		Results:      &results,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var aggregates, rows []string
+	for _, row := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		// run_id,op,count
+		if columns := strings.Split(row, ","); len(columns) >= 3 {
+			aggregates = append(aggregates, strings.Join(columns[:3], ","))
+		}
+	}
+	for _, row := range strings.Split(strings.TrimSpace(results.String()), "\n") {
+		if columns := strings.Split(row, ","); len(columns) >= 6 {
+			rows = append(rows, strings.Join(append(columns[:4:4], columns[6:]...), ","))
+		}
+	}
+	return strings.Join(aggregates, "\n"), strings.Join(rows, "\n")
+}
+
+func TestReuseEVMMatchesExecute(t *testing.T) {
+	// PUSH1 1 PUSH1 2 ADD POP STOP
+	bytecode := []byte{0x60, 0x01, 0x60, 0x02, 0x01, 0x50, 0x00}
+	aggregates, rows := measureReused(t, bytecode, false)
+	reusedAggregates, reusedRows := measureReused(t, bytecode, true)
+	if aggregates == "" || !strings.Contains(rows, ",true,0,5,") {
+		t.Fatalf("expected the 5 executed opcodes of every run, got aggregates:\n%v\nresults:\n%v", aggregates, rows)
+	}
+	if reusedAggregates != aggregates {
+		t.Errorf("aggregates with -reuseEVM:\n%v\nwithout:\n%v", reusedAggregates, aggregates)
+	}
+	if reusedRows != rows {
+		t.Errorf("result rows with -reuseEVM:\n%v\nwithout:\n%v", reusedRows, rows)
+	}
+}
+
+// TestReuseEVMMatchesCall checks that a run of the reused EVM makes the call of execute, which transfers the value to the contract,
+// reverts the state on error and consumes the gas left on an error other than a revert
+func TestReuseEVMMatchesCall(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytecode []byte
+	}{
+		// SELFBALANCE PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN, the value transferred to the contract
+		{"value", []byte{0x47, 0x60, 0x00, 0x52, 0x60, 0x20, 0x60, 0x00, 0xf3}},
+		// PUSH1 1 PUSH1 0 SSTORE PUSH1 0 PUSH1 0 REVERT
+		{"revert", []byte{0x60, 0x01, 0x60, 0x00, 0x55, 0x60, 0x00, 0x60, 0x00, 0xfd}},
+		// PUSH1 1 PUSH1 0 SSTORE INVALID
+		{"invalid", []byte{0x60, 0x01, 0x60, 0x00, 0x55, 0xfe}},
+	}
+	for _, test := range tests {
+		var runs [2][]string
+		for i, reuseEVM := range []bool{false, true} {
+			cfg := newTestConfig(t, "london")
+			cfg.GasLimit = 100000
+			cfg.Value = big.NewInt(7)
+			cfg.Env.SenderBalance = big.NewInt(7)
+			prepareState(cfg, test.bytecode)
+			reuse := newReusableExecution(cfg, nil)
+			for run := 0; run < 2; run++ {
+				var ret []byte
+				var leftOverGas uint64
+				var err error
+				if reuseEVM {
+					ret, leftOverGas, _, _, err = reuse.run()
+				} else {
+					ret, leftOverGas, err = execute(nil, cfg)
+				}
+				runs[i] = append(runs[i], fmt.Sprintf("ret %x, gas left %d, error %v", ret, leftOverGas, err))
+			}
+		}
+		if strings.Join(runs[1], "\n") != strings.Join(runs[0], "\n") {
+			t.Errorf("%v: runs with -reuseEVM:\n%v\nwithout:\n%v", test.name, strings.Join(runs[1], "\n"), strings.Join(runs[0], "\n"))
+		}
+		if test.name == "value" && !strings.HasPrefix(runs[1][0], fmt.Sprintf("ret %064x,", 7)) {
+			t.Errorf("%v: expected the value of 7 wei transferred to the contract, got %v", test.name, runs[1][0])
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package measure
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// StackPrelude returns the code pushing the given words (bottom to top) onto the stack, one PUSH32 each, to be put in front of the
+// measured bytecode. The stack of the interpreter is local to its Run, so the fork gives no way of setting it directly.
+// Fails if the words do not fit the stack limit
+func StackPrelude(words []common.Hash) ([]byte, error) {
+	if uint64(len(words)) > params.StackLimit {
+		return nil, fmt.Errorf("at most %d stack words fit the stack, got %d", params.StackLimit, len(words))
+	}
+	prelude := make([]byte, 0, len(words)*(1+common.HashLength))
+	for _, word := range words {
+		prelude = append(prelude, byte(vm.PUSH32))
+		prelude = append(prelude, word.Bytes()...)
+	}
+	return prelude, nil
+}
+
+// DefaultStackDepths are the depths swept by mode stacksweep, from an empty to a full stack every 16 words, leaving out
+// the depths below minDepth
+func DefaultStackDepths(minDepth int) []int {
+	var depths []int
+	for depth := 0; depth <= int(params.StackLimit); depth += 16 {
+		if depth >= minDepth {
+			depths = append(depths, depth)
+		}
+	}
+	return depths
+}
+
+// StackFill returns the code pushing depth zero words, one PUSH1 0 each, followed by JUMPDESTs up to the length of maxDepth PUSH1s,
+// so that the code behind it is at the same pc for every depth of a sweep and none of the fill is left on top of the stack
+func StackFill(depth int, maxDepth int) []byte {
+	fill := make([]byte, 0, 2*maxDepth)
+	for i := 0; i < depth; i++ {
+		fill = append(fill, byte(vm.PUSH1), 0)
+	}
+	for len(fill) < 2*maxDepth {
+		fill = append(fill, byte(vm.JUMPDEST))
+	}
+	return fill
+}
+
+// MemoryPrelude returns the code expanding the memory to the given number of words, by an MSTORE8 of a zero to its last byte,
+// to be put in front of the measured bytecode, so that it accesses memory already paid for. The memory of the interpreter is
+// local to its Run as its stack is, see StackPrelude. The stack is left as it was
+func MemoryPrelude(words int) ([]byte, error) {
+	if words <= 0 || words*32 > maxPreludeDataSize {
+		return nil, fmt.Errorf("memory size must be between 1 and %d words, got %d", maxPreludeDataSize/32, words)
+	}
+	// PUSH1 0, PUSH4 offset of the last byte, MSTORE8
+	prelude := []byte{byte(vm.PUSH1), 0}
+	prelude = appendPush4(prelude, words*32-1)
+	return append(prelude, byte(vm.MSTORE8)), nil
+}
+
+// maxPreludeDataSize bounds the size of the data of memoryPrelude, which is embedded in the code
+const maxPreludeDataSize = 1 << 24
+
+// memoryPrelude returns the code, starting at pc start, copying (CODECOPY) the data to memory offset 0, the data being embedded
+// in the code and jumped over. It is followed by 32 zero (STOP) bytes, so that the immediate of a PUSH within the data does not
+// cover the JUMPDEST after it
+func memoryPrelude(start int, data []byte) ([]byte, error) {
+	if len(data) > maxPreludeDataSize {
+		return nil, fmt.Errorf("at most %d bytes fit the code, got %d", maxPreludeDataSize, len(data))
+	}
+	// PUSH4 size, PUSH4 data offset, PUSH1 0, CODECOPY, PUSH4 JUMPDEST offset, JUMP, data, padding, JUMPDEST
+	dataOffset := start + 5 + 5 + 2 + 1 + 5 + 1
+	prelude := make([]byte, 0, dataOffset-start+len(data)+32+1)
+	prelude = appendPush4(prelude, len(data))
+	prelude = appendPush4(prelude, dataOffset)
+	prelude = append(prelude, byte(vm.PUSH1), 0, byte(vm.CODECOPY))
+	prelude = appendPush4(prelude, dataOffset+len(data)+32)
+	prelude = append(prelude, byte(vm.JUMP))
+	prelude = append(prelude, data...)
+	prelude = append(prelude, make([]byte, 32)...)
+	return append(prelude, byte(vm.JUMPDEST)), nil
+}
+
+func appendPush4(code []byte, value int) []byte {
+	immediate := make([]byte, 4)
+	binary.BigEndian.PutUint32(immediate, uint32(value))
+	return append(append(code, byte(vm.PUSH4)), immediate...)
+}
+
+// PadCode returns the bytecode followed by size inert bytes, a STOP and INVALIDs (0xfe), so that CODESIZE and CODECOPY see a larger
+// code while a program running past its end still stops there. Fails if the bytecode ends within the immediate of a PUSH,
+// which the padding would change
+func PadCode(bytecode []byte, size int) ([]byte, error) {
+	if size <= 0 {
+		return bytecode, nil
+	}
+	if size > maxPreludeDataSize {
+		return nil, fmt.Errorf("at most %d bytes of padding fit the code, got %d", maxPreludeDataSize, size)
+	}
+	if err := ValidatePushImmediates(bytecode); err != nil {
+		return nil, fmt.Errorf("the padding would change the immediate: %v", err)
+	}
+	padded := make([]byte, len(bytecode), len(bytecode)+size)
+	copy(padded, bytecode)
+	padded = append(padded, byte(vm.STOP))
+	for i := 1; i < size; i++ {
+		padded = append(padded, byte(vm.INVALID))
+	}
+	return padded, nil
+}
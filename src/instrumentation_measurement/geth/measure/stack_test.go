@@ -0,0 +1,73 @@
+package measure
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestMemoryPrelude(t *testing.T) {
+	tests := []struct {
+		name  string
+		start int
+		data  []byte
+	}{
+		{"empty", 0, nil},
+		{"byte", 0, []byte{0x42}},
+		{"word and more", 0, bytes.Repeat([]byte{0x01, 0x02, 0x03}, 20)},
+		{"after other code", 3, []byte{0xaa, 0xbb}},
+	}
+	for _, test := range tests {
+		prelude, err := memoryPrelude(test.start, test.data)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		// JUMPDESTs standing for the code in front, then PUSH4 size PUSH1 0 RETURN of the populated memory
+		code := append(bytes.Repeat([]byte{0x5b}, test.start), prelude...)
+		code = append(appendPush4(code, len(test.data)), 0x60, 0x00, 0xf3)
+		if ret := executeOnce(t, code); !bytes.Equal(ret, test.data) {
+			t.Errorf("%v: memory %x, expected %x", test.name, ret, test.data)
+		}
+	}
+
+	if _, err := memoryPrelude(0, make([]byte, maxPreludeDataSize+1)); err == nil {
+		t.Error("expected an error for data which does not fit the code")
+	}
+}
+
+func TestStackPrelude(t *testing.T) {
+	words := []common.Hash{common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(2))}
+	prelude, err := StackPrelude(words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prelude) != len(words)*(1+common.HashLength) {
+		t.Fatalf("prelude of %d bytes, expected a PUSH32 of %d bytes per word", len(prelude), 1+common.HashLength)
+	}
+	// SUB of the words, then PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN of the difference, top minus bottom
+	code := append(append([]byte{}, prelude...), 0x03, 0x60, 0x00, 0x52, 0x60, 0x20, 0x60, 0x00, 0xf3)
+	if ret := executeOnce(t, code); !bytes.Equal(ret, common.BigToHash(big.NewInt(1)).Bytes()) {
+		t.Errorf("returned %x, expected the top word less the bottom one", ret)
+	}
+
+	// the range past the prelude leaves out the PUSH32s, whatever the length of the bytecode
+	opts := ProgramOptions{MeasuredRange: RangeFrom(uint64(len(prelude)))}
+	if err := opts.MeasuredRange.Validate(code); err != nil {
+		t.Fatal(err)
+	}
+	for _, pc := range []uint64{0, 1 + common.HashLength} {
+		if opts.measuredPc(pc) {
+			t.Errorf("PUSH32 at pc %d measured", pc)
+		}
+	}
+	if !opts.measuredPc(uint64(len(prelude))) || !opts.measuredPc(uint64(len(code)-1)) {
+		t.Error("instructions of the bytecode not measured")
+	}
+
+	if _, err := StackPrelude(make([]common.Hash, params.StackLimit+1)); err == nil {
+		t.Error("expected an error for words which do not fit the stack")
+	}
+}
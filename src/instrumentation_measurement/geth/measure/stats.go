@@ -0,0 +1,167 @@
+package measure
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// reservoirSize is the number of durations kept for percentiles, see DurationStats
+const reservoirSize = 1 << 14
+
+// DurationStats accumulates run durations for summary statistics in constant memory, regardless of the sample size.
+// Count, mean, variance, min and max are exact, mean and variance are updated with Welford's algorithm.
+// Percentiles are taken from a uniform random reservoir of at most reservoirSize durations,
+// so they are exact up to that many runs and estimates beyond, with extreme percentiles (e.g. p99.9) the least accurate.
+type DurationStats struct {
+	n         int
+	meanNs    float64
+	m2        float64
+	min       time.Duration
+	max       time.Duration
+	reservoir []time.Duration
+	random    *rand.Rand
+}
+
+func (s *DurationStats) add(duration time.Duration) {
+	s.n++
+	delta := float64(duration) - s.meanNs
+	s.meanNs += delta / float64(s.n)
+	s.m2 += delta * (float64(duration) - s.meanNs)
+	if s.n == 1 || duration < s.min {
+		s.min = duration
+	}
+	if s.n == 1 || duration > s.max {
+		s.max = duration
+	}
+
+	// reservoir sampling, every duration so far ends up in the reservoir with the same probability
+	if len(s.reservoir) < reservoirSize {
+		s.reservoir = append(s.reservoir, duration)
+		return
+	}
+	if s.random == nil {
+		// fixed seed, so that the summary of the same durations is reproducible
+		s.random = rand.New(rand.NewSource(1))
+	}
+	if i := s.random.Intn(s.n); i < reservoirSize {
+		s.reservoir[i] = duration
+	}
+}
+
+// Count of the collected durations
+func (s *DurationStats) Count() int {
+	return s.n
+}
+
+// Mean of the collected durations
+func (s *DurationStats) Mean() time.Duration {
+	return time.Duration(s.meanNs)
+}
+
+// Variance is the sample variance of the collected durations, in ns^2
+func (s *DurationStats) Variance() float64 {
+	if s.n < 2 {
+		return 0
+	}
+	return s.m2 / float64(s.n-1)
+}
+
+// StandardError of the mean of the collected durations, i.e. its expected deviation from the true mean
+func (s *DurationStats) StandardError() time.Duration {
+	if s.n < 2 {
+		return 0
+	}
+	return time.Duration(math.Sqrt(s.Variance() / float64(s.n)))
+}
+
+// Min of the collected durations
+func (s *DurationStats) Min() time.Duration {
+	return s.min
+}
+
+// Max of the collected durations
+func (s *DurationStats) Max() time.Duration {
+	return s.max
+}
+
+// Percentile using the nearest-rank method over the reservoir, p in [0, 100]
+func (s *DurationStats) Percentile(p float64) time.Duration {
+	if len(s.reservoir) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(s.reservoir))
+	copy(sorted, s.reservoir)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// writeSummary writes mean, median, p90, p99, min and max of the collected durations, then less the overhead, if positive,
+// see ProgramOptions.HarnessOverhead
+func (s *DurationStats) writeSummary(out io.Writer, overhead time.Duration) {
+	if s.Count() == 0 {
+		return
+	}
+	fmt.Fprintf(out, "Summary of %d runs: mean %v, median %v, p90 %v, p99 %v, min %v, max %v\n",
+		s.Count(), s.Mean(), s.Percentile(50), s.Percentile(90), s.Percentile(99), s.Min(), s.Max())
+	if overhead > 0 {
+		fmt.Fprintf(out, "Calibrated summary, less the harness overhead of %v: mean %v, median %v, p90 %v, p99 %v, min %v, max %v\n",
+			overhead, s.Mean()-overhead, s.Percentile(50)-overhead, s.Percentile(90)-overhead,
+			s.Percentile(99)-overhead, s.Min()-overhead, s.Max()-overhead)
+	}
+}
+
+// writeEpochSummary writes the mean of every epoch of the sample, then the spread of the epoch means, i.e. the drift between epochs,
+// next to the mean standard deviation within an epoch, i.e. the jitter. A spread of the means well above the standard error of an
+// epoch mean tells that the results drift over the session
+func writeEpochSummary(out io.Writer, epochs []*DurationStats) {
+	means := new(DurationStats)
+	var withinNs float64
+	for epoch, stats := range epochs {
+		fmt.Fprintf(out, "Epoch %d: mean %v, standard deviation %v over %d runs\n",
+			epoch, stats.Mean(), time.Duration(math.Sqrt(stats.Variance())), stats.Count())
+		means.add(stats.Mean())
+		withinNs += math.Sqrt(stats.Variance()) / float64(len(epochs))
+	}
+	fmt.Fprintf(out, "Between epochs: variance of the means %.0f ns^2, standard deviation %v; within epochs: mean standard deviation %v\n",
+		means.Variance(), time.Duration(math.Sqrt(means.Variance())), time.Duration(withinNs))
+}
+
+// WriteBaselineComparison writes the difference of the mean durations of the target and baseline,
+// along with Welch's t-statistic telling if the difference is significant
+func WriteBaselineComparison(out io.Writer, target *DurationStats, baseline *DurationStats) {
+	if target.Count() == 0 || baseline.Count() == 0 {
+		return
+	}
+	difference, tStatistic := welch(target, baseline)
+	fmt.Fprintf(out, "Difference to baseline: mean %v - %v = %v, t-statistic %.2f\n",
+		target.Mean(), baseline.Mean(), difference, tStatistic)
+}
+
+// WriteForkComparison writes the mean durations of the same program under two forks and their difference, as WriteBaselineComparison
+func WriteForkComparison(out io.Writer, fork string, stats *DurationStats, compareFork string, compareStats *DurationStats) {
+	if stats.Count() == 0 || compareStats.Count() == 0 {
+		return
+	}
+	difference, tStatistic := welch(compareStats, stats)
+	fmt.Fprintf(out, "Difference between forks: mean %v (%v) - %v (%v) = %v, t-statistic %.2f\n",
+		compareStats.Mean(), compareFork, stats.Mean(), fork, difference, tStatistic)
+}
+
+// welch returns the difference of the mean durations and Welch's t-statistic of it, NaN if both samples are constant
+func welch(target *DurationStats, baseline *DurationStats) (time.Duration, float64) {
+	difference := target.Mean() - baseline.Mean()
+	standardError := math.Sqrt(target.Variance()/float64(target.Count()) + baseline.Variance()/float64(baseline.Count()))
+	tStatistic := math.NaN()
+	if standardError > 0 {
+		tStatistic = float64(difference) / standardError
+	}
+	return difference, tStatistic
+}
@@ -0,0 +1,111 @@
+package measure
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func statsOf(durations ...time.Duration) *DurationStats {
+	stats := new(DurationStats)
+	for _, duration := range durations {
+		stats.add(duration)
+	}
+	return stats
+}
+
+func TestDurationStatsPercentile(t *testing.T) {
+	stats := statsOf(30, 10, 40, 20)
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{0, 10},
+		{25, 10},
+		{26, 20},
+		{50, 20},
+		{75, 30},
+		{90, 40},
+		{100, 40},
+	}
+	for _, test := range tests {
+		if percentile := stats.Percentile(test.p); percentile != test.expected {
+			t.Errorf("p%v: %v, expected %v", test.p, percentile, test.expected)
+		}
+	}
+	if percentile := new(DurationStats).Percentile(50); percentile != 0 {
+		t.Errorf("p50 of no durations: %v, expected 0", percentile)
+	}
+}
+
+func TestDurationStatsWelford(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	generated := make([]time.Duration, 1000)
+	for i := range generated {
+		generated[i] = time.Duration(1000000 + random.Intn(1000))
+	}
+	tests := []struct {
+		name      string
+		durations []time.Duration
+	}{
+		{"single", []time.Duration{42}},
+		{"constant", []time.Duration{7, 7, 7}},
+		{"small", []time.Duration{10, 20, 30, 40}},
+		// a large mean next to a small spread, where the naive sum of squares loses precision
+		{"generated", generated},
+	}
+	for _, test := range tests {
+		stats := statsOf(test.durations...)
+
+		// the two-pass mean and sample variance
+		var sum, squares float64
+		min, max := test.durations[0], test.durations[0]
+		for _, duration := range test.durations {
+			sum += float64(duration)
+			if duration < min {
+				min = duration
+			}
+			if duration > max {
+				max = duration
+			}
+		}
+		mean := sum / float64(len(test.durations))
+		for _, duration := range test.durations {
+			squares += (float64(duration) - mean) * (float64(duration) - mean)
+		}
+		variance := 0.0
+		if len(test.durations) > 1 {
+			variance = squares / float64(len(test.durations)-1)
+		}
+
+		if stats.Count() != len(test.durations) {
+			t.Errorf("%v: count %d, expected %d", test.name, stats.Count(), len(test.durations))
+		}
+		if stats.Mean() != time.Duration(mean) {
+			t.Errorf("%v: mean %v, expected %v", test.name, stats.Mean(), time.Duration(mean))
+		}
+		if math.Abs(stats.Variance()-variance) > 1e-9*math.Max(1, variance) {
+			t.Errorf("%v: variance %v, expected %v", test.name, stats.Variance(), variance)
+		}
+		if expected := time.Duration(math.Sqrt(variance / float64(len(test.durations)))); stats.StandardError() != expected {
+			t.Errorf("%v: standard error %v, expected %v", test.name, stats.StandardError(), expected)
+		}
+		if stats.Min() != min || stats.Max() != max {
+			t.Errorf("%v: min %v and max %v, expected %v and %v", test.name, stats.Min(), stats.Max(), min, max)
+		}
+	}
+}
+
+func TestDurationStatsReservoir(t *testing.T) {
+	stats := new(DurationStats)
+	for i := 0; i < reservoirSize+100; i++ {
+		stats.add(time.Duration(i))
+	}
+	if stats.Count() != reservoirSize+100 || len(stats.reservoir) != reservoirSize {
+		t.Errorf("count %d and reservoir of %d, expected %d and %d", stats.Count(), len(stats.reservoir), reservoirSize+100, reservoirSize)
+	}
+	if stats.Min() != 0 || stats.Max() != reservoirSize+99 {
+		t.Errorf("min %v and max %v beyond the reservoir, expected 0 and %v", stats.Min(), stats.Max(), time.Duration(reservoirSize+99))
+	}
+}
@@ -0,0 +1,33 @@
+package measure
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// ErrTimeout is the error of an execution cancelled once it ran longer than the timeout, see ProgramOptions.Timeout
+var ErrTimeout = errors.New("execution timed out")
+
+// startTimeout cancels the execution of the EVM once the timeout passes, if positive, and returns the function ending the wait,
+// which tells if the execution was cancelled. The interpreter checks for the cancellation at every JUMP and JUMPI, which every loop
+// executes, and then ends the execution as if it stopped, with no error. A cancelled EVM stays cancelled, so it cannot be reused
+func startTimeout(evm *vm.EVM, timeout time.Duration) func() bool {
+	if timeout <= 0 {
+		return func() bool { return false }
+	}
+	cancelled := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() {
+		evm.Cancel()
+		close(cancelled)
+	})
+	return func() bool {
+		if timer.Stop() {
+			return false
+		}
+		// the cancellation may still be under way
+		<-cancelled
+		return true
+	}
+}
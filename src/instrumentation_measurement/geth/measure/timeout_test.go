@@ -0,0 +1,45 @@
+package measure
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTimeoutEveryRun checks that every run of an endless loop is cancelled by the timeout, with and without a reused EVM:
+// the sample is skipped after a timed out warm-up run, and every timed out measured run has the status timeout
+func TestTimeoutEveryRun(t *testing.T) {
+	// JUMPDEST PUSH1 0 JUMP
+	loop := []byte{0x5b, 0x60, 0x00, 0x56}
+	for _, reuseEVM := range []bool{false, true} {
+		for _, warmup := range []int{0, 1} {
+			var results bytes.Buffer
+			stats, err := MeasureProgram(newTestConfig(t, "london"), loop, nil, ProgramOptions{
+				Mode:            "total",
+				ReuseEVM:        reuseEVM,
+				Warmup:          warmup,
+				Timeout:         10 * time.Millisecond,
+				ContinueOnError: true,
+				SampleSize:      2,
+				Results:         &results,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Count() != 0 {
+				t.Errorf("reuseEVM %v, warm-up %d: %d run durations, expected none", reuseEVM, warmup, stats.Count())
+			}
+			rows := strings.Split(strings.TrimSpace(results.String()), "\n")
+			// the row of the skipped sample, or a row of every measured run
+			if expected := 2 - warmup; len(rows) != expected {
+				t.Fatalf("reuseEVM %v, warm-up %d: %d result rows, expected %d:\n%v", reuseEVM, warmup, len(rows), expected, results.String())
+			}
+			for _, row := range rows {
+				if columns := strings.Split(row, ","); len(columns) < 7 || columns[6] != "timeout" {
+					t.Errorf("reuseEVM %v, warm-up %d: expected the status timeout, got %v", reuseEVM, warmup, row)
+				}
+			}
+		}
+	}
+}
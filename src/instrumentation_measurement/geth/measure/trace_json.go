@@ -0,0 +1,91 @@
+package measure
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// structLogRes is a copy of the layout of github.com/ethereum/go-ethereum/internal/ethapi StructLogRes,
+// the steps of debug_traceTransaction, which can't be imported from internal, with the label, the code hash and the program index
+// added in batch mode
+type structLogRes struct {
+	Label     string             `json:"label,omitempty"`
+	CodeHash  string             `json:"codeHash,omitempty"`
+	ProgramId *int               `json:"programId,omitempty"`
+	Pc        uint64             `json:"pc"`
+	Op        string             `json:"op"`
+	Gas       uint64             `json:"gas"`
+	GasCost   uint64             `json:"gasCost"`
+	Depth     int                `json:"depth"`
+	Error     string             `json:"error,omitempty"`
+	Stack     *[]string          `json:"stack,omitempty"`
+	Memory    *[]string          `json:"memory,omitempty"`
+	Storage   *map[string]string `json:"storage,omitempty"`
+}
+
+// formatStructLog is github.com/ethereum/go-ethereum/internal/ethapi FormatLogs for a single step.
+// Fields the tracer was configured not to capture are left out
+func formatStructLog(log *vm.StructLog) structLogRes {
+	formatted := structLogRes{
+		Pc:      log.Pc,
+		Op:      log.Op.String(),
+		Gas:     log.Gas,
+		GasCost: log.GasCost,
+		Depth:   log.Depth,
+		Error:   log.ErrorString(),
+	}
+	if log.Stack != nil {
+		stack := make([]string, len(log.Stack))
+		for i, stackValue := range log.Stack {
+			stack[i] = stackValue.Hex()
+		}
+		formatted.Stack = &stack
+	}
+	if log.Memory != nil {
+		memory := make([]string, 0, (len(log.Memory)+31)/32)
+		for i := 0; i+32 <= len(log.Memory); i += 32 {
+			memory = append(memory, fmt.Sprintf("%x", log.Memory[i:i+32]))
+		}
+		formatted.Memory = &memory
+	}
+	if log.Storage != nil {
+		storage := make(map[string]string)
+		for i, storageValue := range log.Storage {
+			storage[fmt.Sprintf("%x", i)] = fmt.Sprintf("%x", storageValue)
+		}
+		formatted.Storage = &storage
+	}
+	return formatted
+}
+
+// TraceBytecodeJSON traces the run like TraceBytecode, printing every step as a JSON line to jsonOut in the layout of
+// debug_traceTransaction struct logs, so that existing trace tooling can read it. Memory is captured with trace.Memory or
+// trace.MemoryLimit, in full, storage with trace.Storage, always in full
+func TraceBytecodeJSON(cfg *Config, bytecode []byte, calldata []byte, trace TraceColumns, jsonOut *JSONWriter, results io.Writer, sampleId int) {
+	tracerConfig := new(vm.LogConfig)
+	setDefaultTracerConfig(tracerConfig)
+	if trace.Memory || trace.MemoryLimit > 0 {
+		// see setDefaultTracerConfig, capturing memory slows down the traced execution considerably
+		tracerConfig.EnableMemory = true
+	}
+	if trace.Storage {
+		tracerConfig.DisableStorage = false
+	}
+
+	tracer := vm.NewStructLogger(tracerConfig)
+	cfg.EVMConfig.Tracer = tracer
+	cfg.EVMConfig.Debug = true
+
+	startUnixNs := time.Now().UnixNano()
+	ret, leftOverGas, err := execute(calldata, cfg)
+	printExecutionError(cfg.Stderr, ret, err)
+	writeResultCSV(results, sampleId, startUnixNs, ret, err, len(tracer.StructLogs()), cfg.GasLimit, leftOverGas)
+
+	logs := tracer.StructLogs()
+	for i := range logs {
+		jsonOut.writeStep(cfg.Stderr, formatStructLog(&logs[i]))
+	}
+}
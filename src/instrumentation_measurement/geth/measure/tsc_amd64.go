@@ -0,0 +1,5 @@
+package measure
+
+// readTSC reads the time stamp counter with RDTSCP, see tsc_amd64.s.
+// The counter only measures cycles at a constant rate if the CPU has an invariant TSC (constant_tsc and nonstop_tsc in /proc/cpuinfo)
+func readTSC() uint64
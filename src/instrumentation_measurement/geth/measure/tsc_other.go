@@ -0,0 +1,9 @@
+//go:build !amd64
+// +build !amd64
+
+package measure
+
+// readTSC falls back to runtimeNano on architectures other than amd64, so "cycles" are nanoseconds
+func readTSC() uint64 {
+	return uint64(runtimeNano())
+}
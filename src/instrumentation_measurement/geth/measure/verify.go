@@ -0,0 +1,12 @@
+package measure
+
+// GasUsed runs the bytecode once, untimed, and returns the gas it used: the gas limit less the gas left over. That is the gas
+// charged by the interpreter, with no intrinsic gas of a transaction, as runtime.Execute charges none, and before the refund.
+// The state is prepared for the run and reverted after it, the error of the execution, if any, is returned along with the gas used (mode verify)
+func GasUsed(cfg *Config, bytecode []byte, calldata []byte) (uint64, error) {
+	snapshot := cfg.State.Snapshot()
+	defer cfg.State.RevertToSnapshot(snapshot)
+	prepareState(cfg, bytecode)
+	_, leftOverGas, err := execute(calldata, cfg)
+	return cfg.GasLimit - leftOverGas, err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// runDurationBuckets are the upper bounds (in seconds) of the buckets of the run duration histogram
+var runDurationBuckets = []float64{1e-6, 2.5e-6, 5e-6, 1e-5, 2.5e-5, 5e-5, 1e-4, 2.5e-4, 5e-4, 1e-3, 1e-2, 1e-1, 1}
+
+// runMetrics counts the measured programs, runs and errors, and the histogram of run durations, see -metricsAddr.
+// Programs may be measured by several workers at once, so all updates are guarded
+type runMetrics struct {
+	mutex    sync.Mutex
+	programs uint64
+	samples  uint64
+	errors   uint64
+	// buckets count the runs not longer than the bound of every bucket, i.e. cumulatively, as in the Prometheus format
+	buckets    []uint64
+	durationsS float64
+}
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{buckets: make([]uint64, len(runDurationBuckets))}
+}
+
+// observeRun adds the duration of a single run to the histogram, see measure.ProgramOptions RunObserver
+func (m *runMetrics) observeRun(duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.samples++
+	m.durationsS += duration.Seconds()
+	for i, bound := range runDurationBuckets {
+		if duration.Seconds() <= bound {
+			m.buckets[i]++
+		}
+	}
+}
+
+// observeProgram counts a measured program, or an error if its measurement failed
+func (m *runMetrics) observeProgram(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err != nil {
+		m.errors++
+		return
+	}
+	m.programs++
+}
+
+// writePrometheus writes the metrics in the Prometheus text exposition format
+func (m *runMetrics) writePrometheus(out io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	writeCounter(out, "measurement_programs_total", "Number of programs measured", m.programs)
+	writeCounter(out, "measurement_samples_total", "Number of measured runs, modes all and total", m.samples)
+	writeCounter(out, "measurement_errors_total", "Number of programs which measurement failed", m.errors)
+
+	const name = "measurement_run_duration_seconds"
+	fmt.Fprintf(out, "# HELP %s Duration of the measured runs, modes all and total\n", name)
+	fmt.Fprintf(out, "# TYPE %s histogram\n", name)
+	for i, bound := range runDurationBuckets {
+		fmt.Fprintf(out, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), m.buckets[i])
+	}
+	fmt.Fprintf(out, "%s_bucket{le=\"+Inf\"} %d\n", name, m.samples)
+	fmt.Fprintf(out, "%s_sum %s\n", name, strconv.FormatFloat(m.durationsS, 'g', -1, 64))
+	fmt.Fprintf(out, "%s_count %d\n", name, m.samples)
+}
+
+func writeCounter(out io.Writer, name string, help string, value uint64) {
+	fmt.Fprintf(out, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(out, "# TYPE %s counter\n", name)
+	fmt.Fprintf(out, "%s %d\n", name, value)
+}
+
+// serveMetrics starts serving the metrics at /metrics of the address in the background, which the runs are observed into through measure.ProgramOptions RunObserver
+func serveMetrics(address string, metrics *runMetrics) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.writePrometheus(w)
+	})
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			fmt.Fprintln(stderr, "Metrics server failed:", err)
+			exit(1)
+		}
+	}()
+}
@@ -0,0 +1,459 @@
+//go:build parquet
+// +build parquet
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parquetAvailable tells if -format parquet is built in, see parquet_other.go
+const parquetAvailable = true
+
+// a row group is written once it has this many rows or values of this many bytes, whichever comes first,
+// so that only a row group is ever buffered
+const (
+	parquetRowGroupRows  = 1 << 18
+	parquetRowGroupBytes = 64 << 20
+)
+
+// parquetMagic starts and ends a Parquet file
+const parquetMagic = "PAR1"
+
+// physical and converted types of the Parquet format, see parquetColumn.physicalType
+const (
+	parquetInt64      = 2
+	parquetDouble     = 5
+	parquetByteArray  = 6
+	parquetNoneType   = -1
+	parquetUTF8       = 0
+	parquetUint64Type = 14
+)
+
+// parquetKind is the type of a column, told by its name, see parquetColumnKind
+type parquetKind int
+
+const (
+	parquetSigned parquetKind = iota
+	parquetUnsigned
+	parquetFloat
+	parquetString
+)
+
+// parquetColumn buffers the values of a column of the current row group. Every column is optional, an empty CSV field is a null
+type parquetColumn struct {
+	name    string
+	kind    parquetKind
+	defined []bool
+	values  []byte
+}
+
+// parquetChunk is a written column chunk of a row group, for the footer
+type parquetChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int64
+	size   int64
+}
+
+// parquetWriter converts the CSV rows written to it (of the given header) into a Parquet file with a typed column per CSV column,
+// written to out a row group at a time. # comment lines (-printMeta) go to the key-value metadata of the file, as key=value.
+// The file is complete only once Close writes the footer. The writes of the CSV rows are mostly unchecked, so the first error
+// is kept and returned by Close as well
+type parquetWriter struct {
+	out       io.Writer
+	header    string
+	columns   []*parquetColumn
+	line      []byte
+	lineCount int
+	rows      int64
+	bytes     int
+	offset    int64
+	rowGroups []parquetRowGroup
+	metadata  [][2]string
+	err       error
+}
+
+func newParquetWriter(out io.Writer, header string) (io.WriteCloser, error) {
+	w := &parquetWriter{out: out, header: header}
+	for _, name := range strings.Split(header, ",") {
+		w.columns = append(w.columns, &parquetColumn{name: name, kind: parquetColumnKind(name)})
+	}
+	if err := w.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// parquetColumnKind tells the type of a CSV column from its name, see measure.CSVHeader. Stack words do not fit 64 bits,
+// gas and its costs do not fit signed 64 bits
+func parquetColumnKind(name string) parquetKind {
+	switch name {
+	case "label", "code_hash", "op", "immediate", "memory", "storage", "fork":
+		return parquetString
+	case "gas", "gas_cost", "static_gas", "dynamic_gas", "cycles", "mallocs", "allocated_bytes":
+		return parquetUnsigned
+	case "percent", "mean_measure_all_time_ns":
+		return parquetFloat
+	}
+	if strings.HasPrefix(name, "stack_") && name != "stack_depth" {
+		return parquetString
+	}
+	return parquetSigned
+}
+
+func (w *parquetWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.line = append(w.line, p...)
+	for {
+		end := bytes.IndexByte(w.line, '\n')
+		if end < 0 {
+			return len(p), nil
+		}
+		line := string(w.line[:end])
+		w.line = w.line[end+1:]
+		if w.err = w.writeLine(line); w.err != nil {
+			return len(p), w.err
+		}
+	}
+}
+
+// Close writes the last row group and the footer, it does not close out
+func (w *parquetWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.line) > 0 {
+		if err := w.writeLine(string(w.line)); err != nil {
+			return err
+		}
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	footer := w.footer()
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(footer)))
+	return w.write(append(append(footer, length...), parquetMagic...))
+}
+
+func (w *parquetWriter) writeLine(line string) error {
+	w.lineCount++
+	line = strings.TrimSuffix(line, "\r")
+	if strings.HasPrefix(line, "#") {
+		keyValue := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "#")), "=", 2)
+		if len(keyValue) == 1 {
+			keyValue = append(keyValue, "")
+		}
+		w.metadata = append(w.metadata, [2]string{keyValue[0], keyValue[1]})
+		return nil
+	}
+	if line == "" || line == w.header {
+		// -csvHeader
+		return nil
+	}
+	// the string fields may be quoted, see measure.CSVField
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.FieldsPerRecord = -1
+	fields, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("line %d: %v", w.lineCount, err)
+	}
+	if len(fields) != len(w.columns) {
+		return fmt.Errorf("line %d has %d columns, the header has %d: %v", w.lineCount, len(fields), len(w.columns), w.header)
+	}
+	for i, field := range fields {
+		if err := w.columns[i].append(field); err != nil {
+			return fmt.Errorf("line %d: %v", w.lineCount, err)
+		}
+		w.bytes += len(field)
+	}
+	w.rows++
+	if w.rows >= parquetRowGroupRows || w.bytes >= parquetRowGroupBytes {
+		return w.flush()
+	}
+	return nil
+}
+
+// append adds a value in the PLAIN encoding
+func (c *parquetColumn) append(field string) error {
+	if field == "" {
+		c.defined = append(c.defined, false)
+		return nil
+	}
+	value := make([]byte, 8)
+	switch c.kind {
+	case parquetSigned:
+		number, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return fmt.Errorf("column %v: %v", c.name, err)
+		}
+		binary.LittleEndian.PutUint64(value, uint64(number))
+	case parquetUnsigned:
+		number, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return fmt.Errorf("column %v: %v", c.name, err)
+		}
+		binary.LittleEndian.PutUint64(value, number)
+	case parquetFloat:
+		number, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return fmt.Errorf("column %v: %v", c.name, err)
+		}
+		binary.LittleEndian.PutUint64(value, math.Float64bits(number))
+	case parquetString:
+		binary.LittleEndian.PutUint32(value, uint32(len(field)))
+		value = append(value[:4], field...)
+	}
+	c.defined = append(c.defined, true)
+	c.values = append(c.values, value...)
+	return nil
+}
+
+// physicalType and convertedType are the types of the column in the schema
+func (c *parquetColumn) physicalType() int32 {
+	switch c.kind {
+	case parquetFloat:
+		return parquetDouble
+	case parquetString:
+		return parquetByteArray
+	}
+	return parquetInt64
+}
+
+func (c *parquetColumn) convertedType() int32 {
+	switch c.kind {
+	case parquetUnsigned:
+		return parquetUint64Type
+	case parquetString:
+		return parquetUTF8
+	}
+	return parquetNoneType
+}
+
+// page is the data page (v1) of the buffered values: the definition levels, RLE-encoded, followed by the values
+func (c *parquetColumn) page() []byte {
+	var levels []byte
+	for i := 0; i < len(c.defined); {
+		run := 1
+		for i+run < len(c.defined) && c.defined[i+run] == c.defined[i] {
+			run++
+		}
+		levels = appendUvarint(levels, uint64(run)<<1)
+		if c.defined[i] {
+			levels = append(levels, 1)
+		} else {
+			levels = append(levels, 0)
+		}
+		i += run
+	}
+	page := make([]byte, 4, 4+len(levels)+len(c.values))
+	binary.LittleEndian.PutUint32(page, uint32(len(levels)))
+	return append(append(page, levels...), c.values...)
+}
+
+// flush writes the buffered rows as a row group, a single uncompressed data page per column
+func (w *parquetWriter) flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	rowGroup := parquetRowGroup{rows: w.rows}
+	for _, column := range w.columns {
+		page := column.page()
+		header := &thriftWriter{}
+		header.begin()
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structField(5)
+		header.i32(1, int32(len(column.defined)))
+		header.i32(2, 0) // PLAIN
+		header.i32(3, 3) // RLE
+		header.i32(4, 3)
+		header.end()
+		header.end()
+		chunk := parquetChunk{offset: w.offset, size: int64(len(header.buf) + len(page)), numValues: int64(len(column.defined))}
+		if err := w.write(append(header.buf, page...)); err != nil {
+			return err
+		}
+		rowGroup.chunks = append(rowGroup.chunks, chunk)
+		rowGroup.size += chunk.size
+		column.defined = column.defined[:0]
+		column.values = column.values[:0]
+	}
+	w.rowGroups = append(w.rowGroups, rowGroup)
+	w.rows = 0
+	w.bytes = 0
+	return nil
+}
+
+// footer is the file metadata
+func (w *parquetWriter) footer() []byte {
+	var rows int64
+	for _, rowGroup := range w.rowGroups {
+		rows += rowGroup.rows
+	}
+	t := &thriftWriter{}
+	t.begin()
+	t.i32(1, 1)
+	t.list(2, thriftStruct, len(w.columns)+1)
+	t.begin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(w.columns)))
+	t.end()
+	for _, column := range w.columns {
+		t.begin()
+		t.i32(1, column.physicalType())
+		t.i32(3, 1) // OPTIONAL
+		t.binary(4, column.name)
+		if convertedType := column.convertedType(); convertedType != parquetNoneType {
+			t.i32(6, convertedType)
+		}
+		t.end()
+	}
+	t.i64(3, rows)
+	t.list(4, thriftStruct, len(w.rowGroups))
+	for _, rowGroup := range w.rowGroups {
+		t.begin()
+		t.list(1, thriftStruct, len(rowGroup.chunks))
+		for i, chunk := range rowGroup.chunks {
+			t.begin()
+			t.i64(2, chunk.offset)
+			t.structField(3)
+			t.i32(1, w.columns[i].physicalType())
+			t.list(2, thriftI32, 2)
+			t.varint(zigzag(0)) // PLAIN
+			t.varint(zigzag(3)) // RLE
+			t.list(3, thriftBinary, 1)
+			t.varint(uint64(len(w.columns[i].name)))
+			t.buf = append(t.buf, w.columns[i].name...)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, chunk.numValues)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, rowGroup.size)
+		t.i64(3, rowGroup.rows)
+		t.end()
+	}
+	if len(w.metadata) > 0 {
+		t.list(5, thriftStruct, len(w.metadata))
+		for _, keyValue := range w.metadata {
+			t.begin()
+			t.binary(1, keyValue[0])
+			t.binary(2, keyValue[1])
+			t.end()
+		}
+	}
+	t.binary(6, "gas-cost-estimator "+mainModuleVersion())
+	t.end()
+	return t.buf
+}
+
+func (w *parquetWriter) write(p []byte) error {
+	n, err := w.out.Write(p)
+	w.offset += int64(n)
+	return err
+}
+
+// types of the Thrift compact protocol, which the Parquet metadata is encoded with
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol, just the parts the Parquet metadata needs.
+// Fields must be written in the order of their ids
+type thriftWriter struct {
+	buf []byte
+	// ids of the last fields written in the enclosing structs
+	lastIds []int
+}
+
+// begin starts a struct, the top level one or an element of a list, see structField for a field
+func (t *thriftWriter) begin() {
+	t.lastIds = append(t.lastIds, 0)
+}
+
+// end ends the innermost struct
+func (t *thriftWriter) end() {
+	t.buf = append(t.buf, 0)
+	t.lastIds = t.lastIds[:len(t.lastIds)-1]
+}
+
+func (t *thriftWriter) field(id int, thriftType byte) {
+	last := &t.lastIds[len(t.lastIds)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta<<4)|thriftType)
+	} else {
+		t.buf = append(t.buf, thriftType)
+		t.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) varint(value uint64) {
+	t.buf = appendUvarint(t.buf, value)
+}
+
+func appendUvarint(buf []byte, value uint64) []byte {
+	encoded := make([]byte, binary.MaxVarintLen64)
+	return append(buf, encoded[:binary.PutUvarint(encoded, value)]...)
+}
+
+func zigzag(value int64) uint64 {
+	return uint64(value<<1) ^ uint64(value>>63)
+}
+
+func (t *thriftWriter) i32(id int, value int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(value)))
+}
+
+func (t *thriftWriter) i64(id int, value int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(value))
+}
+
+func (t *thriftWriter) binary(id int, value string) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(value)))
+	t.buf = append(t.buf, value...)
+}
+
+// list starts a list field of size elements, written next without field headers
+func (t *thriftWriter) list(id int, elementType byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf = append(t.buf, byte(size<<4)|elementType)
+		return
+	}
+	t.buf = append(t.buf, 0xf0|elementType)
+	t.varint(uint64(size))
+}
+
+// structField starts a struct field, ended with end
+func (t *thriftWriter) structField(id int) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
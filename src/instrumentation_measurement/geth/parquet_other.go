@@ -0,0 +1,16 @@
+//go:build !parquet
+// +build !parquet
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// parquetAvailable tells if -format parquet is built in, which takes the parquet build tag, see parquet.go
+const parquetAvailable = false
+
+func newParquetWriter(out io.Writer, header string) (io.WriteCloser, error) {
+	return nil, errors.New("built without Parquet support, rebuild with -tags parquet")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/imapp-pl/gas-cost-estimator/src/instrumentation_measurement/geth/measure"
+)
+
+// maxServedRequestSize bounds the body of a request to the measurement server
+const maxServedRequestSize = 4 << 20
+
+// servedRequest is the body of a request to the measurement server, either a JSON object or just the bytecode (hex).
+// Omitted fields take the values of the flags
+type servedRequest struct {
+	Bytecode   string  `json:"bytecode"`
+	Calldata   *string `json:"calldata"`
+	SampleSize int     `json:"sampleSize"`
+	Mode       string  `json:"mode"`
+	Fork       string  `json:"fork"`
+	Label      string  `json:"label"`
+}
+
+// servedProgram is a servedRequest resolved against the flags, see serve
+type servedProgram struct {
+	bytecode   []byte
+	calldata   []byte
+	sampleSize int
+	mode       string
+	fork       string
+	label      string
+}
+
+// servedJob is a program queued for the measurement workers
+type servedJob struct {
+	program  servedProgram
+	response chan servedResponse
+}
+
+// servedResponse is the JSON response of the measurement server, the summary statistics or the error. Durations are in nanoseconds,
+// output holds what the measurement printed to STDOUT (with -printCSV or -printJSON)
+type servedResponse struct {
+	Runs     int    `json:"runs,omitempty"`
+	MeanNs   int64  `json:"meanNs,omitempty"`
+	MedianNs int64  `json:"medianNs,omitempty"`
+	P90Ns    int64  `json:"p90Ns,omitempty"`
+	MinNs    int64  `json:"minNs,omitempty"`
+	MaxNs    int64  `json:"maxNs,omitempty"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+	status   int
+}
+
+// parseServedRequest reads a JSON request, or a request of just the bytecode, if the body does not start with {
+func parseServedRequest(body []byte) (servedRequest, error) {
+	var request servedRequest
+	body = bytes.TrimSpace(body)
+	if !bytes.HasPrefix(body, []byte("{")) {
+		request.Bytecode = string(body)
+		return request, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		return request, fmt.Errorf("invalid request: %v", err)
+	}
+	return request, nil
+}
+
+// serve measures the programs POSTed to /measure of the address with a pool of workers, each on its own OS thread,
+// pinned to CPU firstCpu+worker, if firstCpu is not negative, as runWorkers does. A worker keeps a config per fork,
+// created by newConfig on the first request, and reverts its state after every request, so that the programs
+// do not see each other's changes. The requests are resolved against the flags by resolve. Returns only on failure
+func serve(address string, workers int, firstCpu int, resolve func(request servedRequest) (servedProgram, error),
+	newConfig func(fork string) *measure.Config,
+	measureProgram func(cfg *measure.Config, program servedProgram, stdout io.Writer) (*measure.DurationStats, error)) error {
+	jobs := make(chan servedJob)
+	for worker := 0; worker < workers; worker++ {
+		go func(worker int) {
+			cpu := -1
+			if firstCpu >= 0 {
+				cpu = firstCpu + worker
+			}
+			pinThread(cpu)
+			configs := make(map[string]*measure.Config)
+			for job := range jobs {
+				cfg, ok := configs[job.program.fork]
+				if !ok {
+					cfg = newConfig(job.program.fork)
+					configs[job.program.fork] = cfg
+				}
+				// the state is reverted once the program is measured, see measure.MeasureProgram
+				var output bytes.Buffer
+				stats, err := measureProgram(cfg, job.program, &output)
+				if err != nil {
+					job.response <- servedResponse{Error: err.Error(), Output: output.String(), status: http.StatusUnprocessableEntity}
+					continue
+				}
+				job.response <- servedResponse{
+					Runs:     stats.Count(),
+					MeanNs:   stats.Mean().Nanoseconds(),
+					MedianNs: stats.Percentile(50).Nanoseconds(),
+					P90Ns:    stats.Percentile(90).Nanoseconds(),
+					MinNs:    stats.Min().Nanoseconds(),
+					MaxNs:    stats.Max().Nanoseconds(),
+					Output:   output.String(),
+					status:   http.StatusOK,
+				}
+			}
+		}(worker)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/measure", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST the bytecode (hex) or a JSON request to measure", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxServedRequestSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var response servedResponse
+		request, err := parseServedRequest(body)
+		var program servedProgram
+		if err == nil {
+			program, err = resolve(request)
+		}
+		if err != nil {
+			response = servedResponse{Error: err.Error(), status: http.StatusBadRequest}
+		} else {
+			job := servedJob{program: program, response: make(chan servedResponse, 1)}
+			jobs <- job
+			response = <-job.response
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(response.status)
+		json.NewEncoder(w).Encode(response)
+	})
+	fmt.Fprintln(info, "Serving measurements at", address+"/measure", "with", workers, "workers")
+	return http.ListenAndServe(address, mux)
+}
+
+// servedMode tells if the mode can be requested from the measurement server, disasm and verify do not measure anything,
+// stacksweep measures more than one program, noise none of the requested and replay a program of a given trace
+func servedMode(mode string) bool {
+	return measure.IsValidMode(mode) && mode != "disasm" && mode != "verify" && mode != "stacksweep" && mode != "noise" && mode != "replay"
+}
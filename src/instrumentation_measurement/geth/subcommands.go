@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// flags is the flag set the command line was parsed with, the subcommand's one if given, see parseCommandLine
+var flags = flag.CommandLine
+
+// commonFlags describe the program and the environment it is executed in, taken by every subcommand executing it
+var commonFlags = []string{
+	"bytecode", "bytecodeFile", "repeatBytecode", "stack", "preMemory", "codePad", "strict", "calldata", "initCode", "gasLimit", "value", "senderBalance", "caller", "address", "nonce", "createCollision",
+	"envFile", "stateFile", "storage", "warmAccess", "deploy", "preimageRecording", "extraEips", "fork", "blockNumber", "blockHash", "hashSeed", "time", "difficulty", "baseFee",
+	"warmup", "timeout", "reportHalt", "continueOnError", "seed", "cpu", "printCSV", "format", "csvHeader", "printMeta", "resultCSV",
+	"outFile", "gzip", "errFile", "quiet", "metricsAddr", "printConfig", "label", "codeHash",
+}
+
+// measureFlags configure the measured sample, taken by measure and batch
+var measureFlags = []string{
+	"mode", "sampleSize", "targetSEM", "maxSamples", "calibrate", "epochs", "epochPause", "printEach", "printJSON", "timer",
+	"reuseEVM", "aggregate", "summary", "gcMode", "measureRange", "traceBranch", "expectGas",
+}
+
+// subcommand is a verb selecting a group of modes, with a flag set of the flags relevant to these modes only
+type subcommand struct {
+	usage string
+	// mode the verb runs, measure and batch take it from -mode
+	mode  string
+	flags [][]string
+}
+
+var subcommands = map[string]subcommand{
+	"measure": {
+		usage: "measure [flags] - measures the bytecode in the given -mode (all by default)",
+		flags: [][]string{commonFlags, measureFlags, {"baseline", "compareFork", "logDataSize", "precompile", "precompileInput", "precompileInputSize", "stackDepth", "replayTrace", "calldataSizes", "serve", "workers"}},
+	},
+	"trace": {
+		usage: "trace [flags] - traces every executed opcode (mode trace, or traceJSON with -printJSON)",
+		mode:  "trace",
+		flags: [][]string{commonFlags, {"logDataSize", "sampleSize", "printJSON", "traceStackDepth", "traceMemory", "traceMemoryLimit", "traceOpNumeric", "traceStorage", "traceStorageDelta", "traceBranch"}},
+	},
+	"disasm": {
+		usage: "disasm [flags] - prints the instructions of the bytecode without executing it (mode disasm)",
+		mode:  "disasm",
+		flags: [][]string{{"bytecode", "bytecodeFile", "repeatBytecode", "stack", "preMemory", "codePad", "strict", "label", "codeHash", "csvHeader", "outFile", "gzip", "errFile"}},
+	},
+	"verify": {
+		usage: "verify [flags] - runs the bytecode once and fails if the gas it used differs from -expectGas (mode verify)",
+		mode:  "verify",
+		flags: [][]string{commonFlags, {"expectGas"}},
+	},
+	"stacksweep": {
+		usage: "stacksweep [flags] - times every opcode of the bytecode with the stack filled to a sweep of -stackDepth (mode stacksweep)",
+		mode:  "stacksweep",
+		flags: [][]string{commonFlags, {"stackDepth", "sampleSize", "printEach", "traceBranch"}},
+	},
+	"replay": {
+		usage: "replay [flags] - measures only the instructions of a prior trace of the bytecode, -replayTrace (mode replay)",
+		mode:  "replay",
+		flags: [][]string{commonFlags, {"replayTrace", "measureRange", "sampleSize", "printEach", "printJSON", "aggregate", "summary", "reuseEVM", "compareFork", "calldataSizes"}},
+	},
+	"noise": {
+		usage: "noise [flags] - times a single STOP many times, and fails if the environment is not quiet enough (mode noise)",
+		mode:  "noise",
+		flags: [][]string{commonFlags, {"sampleSize", "gcMode", "timer"}},
+	},
+	"batch": {
+		usage: "batch [flags] <file or directory> - measures every program of the file, one bytecode per line (see -batchFile), or every .hex file of the directory (see -dir)",
+		flags: [][]string{commonFlags, measureFlags, {"workers"}},
+	},
+}
+
+// parseCommandLine parses the arguments either as a subcommand, if the first one is a verb, or as plain flags,
+// which is the original command line and takes all the flags. The subcommand flag sets share the values of the plain flags
+func parseCommandLine(arguments []string) error {
+	flag.CommandLine.Usage = func() {
+		output := flag.CommandLine.Output()
+		fmt.Fprintf(output, "Usage of %s:\n  %s [flags]\n  %s <subcommand> [flags], with the subcommands:\n", os.Args[0], os.Args[0], os.Args[0])
+		for _, name := range subcommandNames() {
+			fmt.Fprintln(output, "    "+subcommands[name].usage)
+		}
+		fmt.Fprintln(output, "Flags:")
+		flag.PrintDefaults()
+	}
+	if len(arguments) == 0 || strings.HasPrefix(arguments[0], "-") {
+		return flag.CommandLine.Parse(arguments)
+	}
+
+	name := arguments[0]
+	command, ok := subcommands[name]
+	if !ok {
+		flag.CommandLine.Usage()
+		return fmt.Errorf("unknown subcommand %v", name)
+	}
+	subcommandFlags := flag.NewFlagSet(name, flag.ExitOnError)
+	for _, group := range command.flags {
+		for _, flagName := range group {
+			f := flag.CommandLine.Lookup(flagName)
+			if f == nil {
+				return fmt.Errorf("subcommand %v takes the undefined flag -%v", name, flagName)
+			}
+			subcommandFlags.Var(f.Value, f.Name, f.Usage)
+		}
+	}
+	subcommandFlags.Usage = func() {
+		fmt.Fprintln(subcommandFlags.Output(), "Usage of", os.Args[0], command.usage)
+		subcommandFlags.PrintDefaults()
+	}
+	if err := subcommandFlags.Parse(arguments[1:]); err != nil {
+		return err
+	}
+	flags = subcommandFlags
+
+	if name == "batch" {
+		if subcommandFlags.NArg() != 1 {
+			subcommandFlags.Usage()
+			return fmt.Errorf("batch takes exactly one file or directory, got %d arguments", subcommandFlags.NArg())
+		}
+		if info, err := os.Stat(subcommandFlags.Arg(0)); err == nil && info.IsDir() {
+			return flag.CommandLine.Set("dir", subcommandFlags.Arg(0))
+		}
+		return flag.CommandLine.Set("batchFile", subcommandFlags.Arg(0))
+	}
+	if subcommandFlags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v", subcommandFlags.Args())
+	}
+	switch {
+	case name == "trace" && isFlagSet("printJSON"):
+		return flag.CommandLine.Set("mode", "traceJSON")
+	case command.mode != "":
+		return flag.CommandLine.Set("mode", command.mode)
+	}
+	return nil
+}
+
+func subcommandNames() []string {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/imapp-pl/gas-cost-estimator/src/instrumentation_measurement/geth/measure"
+)
+
+// validateFlags fails on a flag the mode does not take, or on flags which cannot be combined, before anything is read or executed.
+// The flags which values are parsed (bytecode, sizes, addresses etc.) are validated as they are parsed. batch tells if the programs
+// come from -batchFile or -dir
+func validateFlags(mode string, batch bool) error {
+	if !measure.IsValidMode(mode) {
+		return fmt.Errorf("Invalid measurement mode: %v", mode)
+	}
+
+	// mode stacksweep is mode opcode of the bytecode behind stack fills, see measure.StackFill
+	sweepStack := mode == "stacksweep"
+	if sweepStack {
+		if *measureRangePtr != "" || batch || *logDataSizePtr != "" || *precompilePtr != "" || *servePtr != "" {
+			return errors.New("-mode stacksweep is not available with -measureRange, -batchFile, -logDataSize, -precompile and -serve")
+		}
+		mode = "opcode"
+	} else if *stackDepthPtr != "" {
+		return errors.New("-stackDepth is only available in mode stacksweep")
+	}
+
+	// mode replay is mode all of the instructions at the pcs of a prior trace
+	if mode == "replay" {
+		if *replayTracePtr == "" {
+			return errors.New("-mode replay requires the trace to replay, -replayTrace")
+		}
+		if batch || *baselinePtr != "" || *logDataSizePtr != "" || *precompilePtr != "" || *servePtr != "" {
+			return errors.New("-mode replay is not available with -batchFile, -baseline, -logDataSize, -precompile and -serve")
+		}
+		mode = "all"
+	} else if *replayTracePtr != "" {
+		return errors.New("-replayTrace is only available in mode replay")
+	}
+
+	if *batchFilePtr != "" && *dirPtr != "" {
+		return errors.New("-dir and -batchFile are mutually exclusive")
+	}
+
+	if *reuseEVMPtr && mode != "all" && mode != "total" {
+		return errors.New("-reuseEVM is only available in modes all and total")
+	}
+
+	if *baselinePtr != "" && (batch || (mode != "all" && mode != "total")) {
+		return errors.New("-baseline is only available in modes all and total, without -batchFile")
+	}
+
+	if *compareForkPtr != "" && (batch || *baselinePtr != "" || *workersPtr > 1 || (mode != "all" && mode != "total")) {
+		return errors.New("-compareFork is only available in modes all and total, without -batchFile, -baseline and -workers")
+	}
+
+	if *formatPtr != "csv" && *formatPtr != "parquet" {
+		return fmt.Errorf("Invalid format: %v", *formatPtr)
+	}
+	if *formatPtr == "parquet" && !parquetAvailable {
+		return errors.New("-format parquet is not available, the binary was built without -tags parquet")
+	}
+	if *formatPtr == "parquet" && (*printJSONPtr || *servePtr != "" || mode == "disasm" || mode == "traceJSON" || mode == "flamegraph") {
+		return errors.New("-format parquet is not available in modes disasm, traceJSON and flamegraph, nor with -printJSON and -serve")
+	}
+
+	if mode == "noise" {
+		if *codeHashPtr {
+			return errors.New("-codeHash is not available in mode noise, which measures a single STOP")
+		}
+		if batch || *baselinePtr != "" || *compareForkPtr != "" || *logDataSizePtr != "" || *precompilePtr != "" {
+			return errors.New("-mode noise measures a single STOP, so it is not available with -batchFile, -baseline, -compareFork, -logDataSize and -precompile")
+		}
+	}
+
+	if (mode == "verify") != (*expectGasPtr >= 0) {
+		return errors.New("-expectGas is required by mode verify, and only available in mode verify")
+	}
+
+	if *measureRangePtr != "" && mode != "all" && mode != "opcode" {
+		return errors.New("-measureRange is only available in modes all and opcode")
+	}
+
+	if *precompilePtr != "" && (*bytecodePtr != "" || *bytecodeFilePtr != "" || batch || *baselinePtr != "" || *logDataSizePtr != "" || *servePtr != "") {
+		return errors.New("-precompile measures a generated program, so it is not available with -bytecode, -bytecodeFile, -batchFile, -baseline, -logDataSize and -serve")
+	}
+	if *precompilePtr == "" && (*precompileInputPtr != "" || *precompileInputSizePtr != "") {
+		return errors.New("-precompileInput and -precompileInputSize are only available with -precompile")
+	}
+	if *precompileInputSizePtr != "" && *compareForkPtr != "" {
+		return errors.New("-precompileInputSize is not available with -compareFork")
+	}
+
+	if *logDataSizePtr != "" && (batch || *baselinePtr != "" || *compareForkPtr != "") {
+		return errors.New("-logDataSize is not available with -batchFile, -baseline and -compareFork")
+	}
+
+	if *calldataSizesPtr != "" && (batch || *baselinePtr != "" || *compareForkPtr != "" || *logDataSizePtr != "" || *precompilePtr != "" || *initCodePtr != "" || *servePtr != "" || sweepStack || mode == "noise") {
+		return errors.New("-calldataSizes is not available in modes stacksweep and noise, nor with -batchFile, -baseline, -compareFork, -logDataSize, -precompile, -initCode and -serve")
+	}
+
+	if err := validateLabel(*labelPtr); err != nil {
+		return fmt.Errorf("Invalid label: %v", err)
+	}
+
+	if *servePtr != "" && (batch || *baselinePtr != "" || *compareForkPtr != "" || *logDataSizePtr != "" || *calibratePtr || !servedMode(mode)) {
+		return errors.New("-serve is not available in mode disasm, nor with -batchFile, -baseline, -compareFork, -logDataSize and -calibrate")
+	}
+
+	if (*reportHaltPtr || *initCodePtr != "") && *warmupPtr < 1 {
+		return errors.New("-reportHalt and -initCode require at least one warm-up run")
+	}
+	if *initCodePtr != "" && isFlagSet("calldata") {
+		return errors.New("-initCode is passed as calldata, so it cannot be combined with -calldata")
+	}
+
+	if *repeatBytecodePtr < 1 {
+		return fmt.Errorf("Invalid repeat count: %d", *repeatBytecodePtr)
+	}
+
+	if mode == "traceJSON" && !*printJSONPtr {
+		return errors.New("-mode traceJSON prints JSON lines only, so it requires -printJSON")
+	}
+
+	if *targetSEMPtr > 0 && mode != "all" && mode != "total" {
+		return errors.New("-targetSEM is only available in modes all and total")
+	}
+
+	if *calibratePtr && mode != "all" && mode != "total" {
+		return errors.New("-calibrate is only available in modes all and total")
+	}
+	if *calibratePtr && *workersPtr > 1 {
+		return errors.New("-calibrate is not available with -workers, as every worker runs on its own CPU")
+	}
+
+	if *epochsPtr < 1 {
+		return fmt.Errorf("Invalid number of epochs: %d", *epochsPtr)
+	}
+	if *epochsPtr > 1 && mode != "all" && mode != "total" {
+		return errors.New("-epochs is only available in modes all and total")
+	}
+	if *epochsPtr > 1 && *targetSEMPtr > 0 {
+		return errors.New("-epochs cannot be combined with -targetSEM, as the epochs would differ in size")
+	}
+
+	if *traceStackDepthPtr < 0 {
+		return fmt.Errorf("Invalid trace stack depth: %d", *traceStackDepthPtr)
+	}
+
+	if *workersPtr > 1 && !batch && *servePtr == "" {
+		return errors.New("-workers is only available with -batchFile, -dir and -serve")
+	}
+
+	if *gcModePtr != "default" && *gcModePtr != "each" && *gcModePtr != "off" {
+		return fmt.Errorf("Invalid GC mode: %v", *gcModePtr)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/imapp-pl/gas-cost-estimator/src/instrumentation_measurement/geth/measure"
+)
+
+// workerOutput is the buffered output of a single program, written out in program order
+type workerOutput struct {
+	programId int
+	stdout    bytes.Buffer
+	results   bytes.Buffer
+	err       error
+}
+
+// runWorkers measures the programs in parallel. Every worker has its own config, state and instrumenters
+// and runs on its own OS thread, pinned to CPU firstCpu+worker, if firstCpu is not negative.
+// The output of every program is buffered and written out in program order, up to the first program which failed,
+// which error is returned.
+func runWorkers(workers int, firstCpu int, programs [][]byte, newConfig func() *measure.Config,
+	measure func(cfg *measure.Config, programId int, bytecode []byte, stdout io.Writer, results io.Writer) (*measure.DurationStats, error),
+	stdout io.Writer, results io.Writer) error {
+	programIds := make(chan int)
+	outputs := make(chan *workerOutput)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			cpu := -1
+			if firstCpu >= 0 {
+				cpu = firstCpu + worker
+			}
+			pinThread(cpu)
+			cfg := newConfig()
+			for programId := range programIds {
+				output := &workerOutput{programId: programId}
+				var programResults io.Writer
+				if results != nil {
+					programResults = &output.results
+				}
+				_, output.err = measure(cfg, programId, programs[programId], &output.stdout, programResults)
+				outputs <- output
+			}
+		}(worker)
+	}
+	go func() {
+		for programId := range programs {
+			programIds <- programId
+		}
+		close(programIds)
+		wg.Wait()
+		close(outputs)
+	}()
+
+	// reorder, so that the output is the same as if measured sequentially
+	pending := make(map[int]*workerOutput)
+	next := 0
+	for output := range outputs {
+		pending[output.programId] = output
+		for ; pending[next] != nil; next++ {
+			stdout.Write(pending[next].stdout.Bytes())
+			if results != nil {
+				results.Write(pending[next].results.Bytes())
+			}
+			if pending[next].err != nil {
+				// the remaining workers are left behind, as the process exits
+				return pending[next].err
+			}
+			delete(pending, next)
+		}
+	}
+	return nil
+}
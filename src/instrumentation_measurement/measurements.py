@@ -45,7 +45,7 @@ class Measurements(object):
 
   There is also a special `mode` parameter value: `trace`, which produces a CSV in the following format:
   ```
-  | program_id | sample_id | instruction_id | pc | op | stack_depth | arg_0 | arg_... |
+  | program_id | sample_id | instruction_id | pc | op | gas | gas_cost | stack_depth | arg_0 | arg_... |
   ```
   """
 
@@ -118,7 +118,7 @@ class Measurements(object):
         header = "program_id,sample_id,run_id,instruction_id,measure_all_time_ns,measure_all_timer_time_ns"
         print(header)
     elif mode == trace_opcodes:
-        header = "program_id,sample_id,instruction_id,pc,op,stack_depth"
+        header = "program_id,sample_id,instruction_id,pc,op,gas,gas_cost,stack_depth"
         for i in range(MAX_OPCODE_ARGS):
             elem = ",arg_{}".format(i)
             header += elem
@@ -255,10 +255,10 @@ class Measurements(object):
       result = []
       for row in tracer_result:
         row = row.split(',')
-        prefix = row[0:4] # take first columns untouched
+        prefix = row[0:6] # take first columns untouched
         opcode = row[2]
-        stack_depth = int(row[3])
-        stack = row[4:]
+        stack_depth = int(row[5])
+        stack = row[6:]
 
         args = None
         match_result = re.search(r'^(DUP|PUSH|SWAP)([1-9][0-9]?)$', opcode)